@@ -0,0 +1,530 @@
+package analysis
+
+import (
+  "sort"
+  "strconv"
+  "strings"
+  "text/template/parse"
+)
+
+// maxDepth bounds how deeply {{ template }} and partial calls are followed.
+const maxDepth = 32
+
+// Report lists what a template and the templates it calls refer to.
+type Report struct {
+  Fields    []*Field
+  Functions []*Function
+  Partials  []*Partial
+}
+
+// Location is the position of a node in a template's source.
+type Location struct {
+  Template string
+  Line     int
+  Column   int
+}
+
+// Field is a field chain referenced by a template, such as ".title" or
+// "$author.name".
+type Field struct {
+  Location
+  // Chain is the field chain as written in the template.
+  Chain string
+  // Path is the chain resolved against the data passed to the template,
+  // with elements of ranged over lists and maps written as "*", e.g.
+  // "items.*.title". It is empty if the chain could not be resolved.
+  Path string
+}
+
+// Function is a call to a template function.
+type Function struct {
+  Location
+  Name string
+  // Args is the number of arguments passed to the function, including a
+  // value piped into it.
+  Args int
+  // Operands describe each of the arguments, with a piped value last.
+  Operands []Operand
+}
+
+// Operand is an argument passed to a function.
+type Operand struct {
+  // Text is the argument as written in the template. It is empty for a
+  // piped value.
+  Text string
+  // Path is the resolved path of an argument read from the data, as for
+  // Field.Path.
+  Path string
+  // Literal is the kind of a constant argument: "string", "number", "bool"
+  // or "nil".
+  Literal string
+}
+
+// Partial is a call to partial or partialCached.
+type Partial struct {
+  Location
+  Name string
+  // Context is the resolved path of the context passed to the partial, as
+  // for Field.Path.
+  Context string
+  // Found reports whether the partial was among the analyzed templates.
+  Found bool
+}
+
+// Parse parses text as the named template, adding it and the templates it
+// defines to trees. Templates already in trees are replaced, as text/template
+// does when a block is redefined. Functions are not checked, so that
+// templates calling unknown functions can still be analyzed.
+func Parse(trees map[string]*parse.Tree, name, text, leftDelim, rightDelim string) error {
+  t := parse.New(name)
+  t.Mode = parse.SkipFuncCheck
+
+  parsed := make(map[string]*parse.Tree)
+  if _, err := t.Parse(text, leftDelim, rightDelim, parsed); err != nil {
+    return err
+  }
+
+  for n, tree := range parsed {
+    if old, found := trees[n]; found && parse.IsEmptyTree(tree.Root) && !parse.IsEmptyTree(old.Root) {
+      continue
+    }
+    trees[n] = tree
+  }
+  return nil
+}
+
+// Analyze walks the named template of trees and every template and partial
+// it calls, returning the fields, functions and partials they refer to.
+func Analyze(trees map[string]*parse.Tree, name string) *Report {
+  a := &analyzer{trees: trees, report: &Report{}, seen: make(map[string]bool)}
+  if tree := trees[name]; tree != nil {
+    a.walkTree(tree, root)
+  }
+
+  sort.SliceStable(a.report.Fields, func(i, j int) bool {
+    return a.report.Fields[i].Location.less(a.report.Fields[j].Location)
+  })
+  sort.SliceStable(a.report.Functions, func(i, j int) bool {
+    return a.report.Functions[i].Location.less(a.report.Functions[j].Location)
+  })
+  sort.SliceStable(a.report.Partials, func(i, j int) bool {
+    return a.report.Partials[i].Location.less(a.report.Partials[j].Location)
+  })
+  return a.report
+}
+
+// FieldPaths returns the distinct resolved paths of the fields in r.
+func (r *Report) FieldPaths() []string {
+  return distinct(len(r.Fields), func(i int) string { return r.Fields[i].Path })
+}
+
+// FunctionNames returns the distinct names of the functions in r.
+func (r *Report) FunctionNames() []string {
+  return distinct(len(r.Functions), func(i int) string { return r.Functions[i].Name })
+}
+
+// PartialNames returns the distinct names of the partials in r.
+func (r *Report) PartialNames() []string {
+  return distinct(len(r.Partials), func(i int) string { return r.Partials[i].Name })
+}
+
+func distinct(n int, get func(i int) string) []string {
+  seen := make(map[string]bool)
+  var values []string
+  for i := 0; i < n; i++ {
+    v := get(i)
+    if v != "" && !seen[v] {
+      seen[v] = true
+      values = append(values, v)
+    }
+  }
+  sort.Strings(values)
+  return values
+}
+
+func (l Location) String() string {
+  return l.Template + ":" + strconv.Itoa(l.Line) + ":" + strconv.Itoa(l.Column)
+}
+
+// String returns the location and chain of the field, along with its path
+// if it was resolved, e.g. "preview:1:3: $post.title (posts.*.title)".
+// Without it, fmt would print the embedded Location only.
+func (f Field) String() string {
+  s := f.Location.String() + ": " + f.Chain
+  if f.Path != "" {
+    s += " (" + f.Path + ")"
+  }
+  return s
+}
+
+// String returns the location, name and number of arguments of the call,
+// e.g. "preview:1:3: where/3".
+func (f Function) String() string {
+  return f.Location.String() + ": " + f.Name + "/" + strconv.Itoa(f.Args)
+}
+
+// String returns the location and name of the partial call, e.g.
+// `preview:1:3: partial "header.html"`, noting partials that were not
+// found.
+func (p Partial) String() string {
+  s := p.Location.String() + ": partial " + strconv.Quote(p.Name)
+  if !p.Found {
+    s += " (not found)"
+  }
+  return s
+}
+
+func (l Location) less(o Location) bool {
+  if l.Template != o.Template {
+    return l.Template < o.Template
+  }
+  if l.Line != o.Line {
+    return l.Line < o.Line
+  }
+  return l.Column < o.Column
+}
+
+// value is what the analyzer knows about a value in a template: the path it
+// was read from in the data, if any.
+type value struct {
+  path  []string
+  known bool
+}
+
+var (
+  root    = value{known: true}
+  unknown = value{}
+)
+
+// scratchKey is the key the render package adds the scratch of a render to
+// the data under. It is not an entry field.
+const scratchKey = "Scratch"
+
+func (v value) child(names ...string) value {
+  if !v.known || len(v.path) == 0 && len(names) > 0 && names[0] == scratchKey {
+    return unknown
+  }
+  path := make([]string, 0, len(v.path)+len(names))
+  path = append(path, v.path...)
+  path = append(path, names...)
+  return value{path: path, known: true}
+}
+
+func (v value) String() string {
+  if !v.known {
+    return ""
+  }
+  return strings.Join(v.path, ".")
+}
+
+type analyzer struct {
+  trees  map[string]*parse.Tree
+  report *Report
+  seen   map[string]bool
+  stack  []string
+}
+
+// scope is the state of a walk within a single template.
+type scope struct {
+  tree *parse.Tree
+  vars map[string]value
+}
+
+func (s *scope) with(vars map[string]value) *scope {
+  c := make(map[string]value, len(s.vars)+len(vars))
+  for k, v := range s.vars {
+    c[k] = v
+  }
+  for k, v := range vars {
+    c[k] = v
+  }
+  return &scope{tree: s.tree, vars: c}
+}
+
+// walkTree walks tree with dot set to v, unless it is already being walked
+// or the call depth is exceeded.
+func (a *analyzer) walkTree(tree *parse.Tree, v value) {
+  if tree == nil || tree.Root == nil || len(a.stack) >= maxDepth {
+    return
+  }
+  for _, name := range a.stack {
+    if name == tree.Name {
+      return
+    }
+  }
+
+  a.stack = append(a.stack, tree.Name)
+  a.walk(tree.Root, v, &scope{tree: tree, vars: map[string]value{"$": v}})
+  a.stack = a.stack[:len(a.stack)-1]
+}
+
+func (a *analyzer) walk(node parse.Node, dot value, s *scope) {
+  switch n := node.(type) {
+  case *parse.ListNode:
+    if n == nil {
+      return
+    }
+    for _, child := range n.Nodes {
+      a.walk(child, dot, s)
+    }
+  case *parse.ActionNode:
+    a.pipe(n.Pipe, dot, s)
+  case *parse.IfNode:
+    inner := s.with(nil)
+    a.pipe(n.Pipe, dot, inner)
+    a.walk(n.List, dot, inner)
+    a.walk(n.ElseList, dot, inner)
+  case *parse.WithNode:
+    inner := s.with(nil)
+    v := a.pipe(n.Pipe, dot, inner)
+    a.walk(n.List, v, inner)
+    a.walk(n.ElseList, dot, inner)
+  case *parse.RangeNode:
+    inner := s.with(nil)
+    v := a.pipe(n.Pipe, dot, inner)
+    elem := v.child("*")
+    switch len(n.Pipe.Decl) {
+    case 1:
+      inner.vars[n.Pipe.Decl[0].Ident[0]] = elem
+    case 2:
+      inner.vars[n.Pipe.Decl[0].Ident[0]] = unknown
+      inner.vars[n.Pipe.Decl[1].Ident[0]] = elem
+    }
+    a.walk(n.List, elem, inner)
+    a.walk(n.ElseList, dot, inner)
+  case *parse.TemplateNode:
+    v := unknown
+    if n.Pipe != nil {
+      v = a.pipe(n.Pipe, dot, s)
+    }
+    a.walkTree(a.trees[n.Name], v)
+  }
+}
+
+// pipe records what the pipeline refers to and returns its value. Variables
+// it declares are added to s.
+func (a *analyzer) pipe(pipe *parse.PipeNode, dot value, s *scope) value {
+  if pipe == nil {
+    return unknown
+  }
+
+  v := unknown
+  for i, cmd := range pipe.Cmds {
+    var piped *value
+    if i > 0 {
+      piped = &v
+    }
+    v = a.command(cmd, dot, s, piped)
+  }
+
+  for _, decl := range pipe.Decl {
+    if len(pipe.Decl) == 1 {
+      s.vars[decl.Ident[0]] = v
+    } else {
+      s.vars[decl.Ident[0]] = unknown
+    }
+  }
+  return v
+}
+
+// command records what cmd refers to and returns its value. piped is the
+// value of the previous command in the pipeline, if any.
+func (a *analyzer) command(cmd *parse.CommandNode, dot value, s *scope, piped *value) value {
+  if len(cmd.Args) == 0 {
+    return unknown
+  }
+
+  ident, isFunc := cmd.Args[0].(*parse.IdentifierNode)
+  if !isFunc {
+    v := a.arg(cmd.Args[0], dot, s)
+    for _, arg := range cmd.Args[1:] {
+      a.arg(arg, dot, s)
+    }
+    if len(cmd.Args) > 1 || piped != nil {
+      // A method call.
+      return unknown
+    }
+    return v
+  }
+
+  args := make([]value, 0, len(cmd.Args))
+  operands := make([]Operand, 0, len(cmd.Args))
+  for _, arg := range cmd.Args[1:] {
+    v := a.arg(arg, dot, s)
+    args = append(args, v)
+    operands = append(operands, Operand{Text: arg.String(), Path: v.String(), Literal: literalKind(arg)})
+  }
+  if piped != nil {
+    args = append(args, *piped)
+    operands = append(operands, Operand{Path: piped.String()})
+  }
+
+  loc := a.location(s.tree, ident)
+  if key := "func:" + loc.String(); !a.seen[key] {
+    a.seen[key] = true
+    a.report.Functions = append(a.report.Functions, &Function{
+      Location: loc,
+      Name:     ident.Ident,
+      Args:     len(args),
+      Operands: operands,
+    })
+  }
+
+  literal := func(i int) (string, bool) {
+    if i+1 >= len(cmd.Args) {
+      return "", false
+    }
+    str, ok := cmd.Args[i+1].(*parse.StringNode)
+    if !ok {
+      return "", false
+    }
+    return str.Text, true
+  }
+
+  switch ident.Ident {
+  case "first":
+    // first N list
+    if len(args) == 2 {
+      return args[1]
+    }
+  case "where":
+    // where list "key" [op] match, or list | where "key" [op] match, where
+    // the list is piped in last.
+    list, key := 0, 1
+    if piped != nil {
+      list, key = len(args)-1, 0
+    }
+    if len(args) >= 2 {
+      if name, ok := literal(key); ok {
+        a.addField(s.tree, cmd.Args[key+1], strconv.Quote(name), args[list].child("*").child(splitKey(name)...))
+      }
+      return args[list]
+    }
+  case "index":
+    if len(args) >= 1 {
+      v := args[0]
+      for i := 1; i < len(args); i++ {
+        if key, ok := literal(i); ok {
+          v = v.child(key)
+        } else {
+          v = v.child("*")
+        }
+      }
+      return v
+    }
+  case "partial", "partialCached":
+    if name, ok := literal(0); ok {
+      context := unknown
+      if len(args) >= 2 {
+        context = args[1]
+      }
+      a.partial(s, ident, name, context)
+    }
+  }
+
+  return unknown
+}
+
+func (a *analyzer) partial(s *scope, node parse.Node, name string, context value) {
+  name = strings.TrimPrefix(name, "partials/")
+  p := &Partial{
+    Location: a.location(s.tree, node),
+    Name:     name,
+    Context:  context.String(),
+  }
+  if key := "partial:" + p.Location.String() + ":" + p.Context; !a.seen[key] {
+    a.seen[key] = true
+    a.report.Partials = append(a.report.Partials, p)
+  }
+
+  for _, n := range []string{"partials/" + name, "theme/partials/" + name} {
+    tree := a.trees[n]
+    if tree == nil {
+      tree = a.trees[n+".html"]
+    }
+    if tree != nil {
+      p.Found = true
+      a.walkTree(tree, context)
+      return
+    }
+  }
+}
+
+// arg records what a command argument refers to and returns its value.
+func (a *analyzer) arg(node parse.Node, dot value, s *scope) value {
+  switch n := node.(type) {
+  case *parse.DotNode:
+    return dot
+  case *parse.FieldNode:
+    v := dot.child(n.Ident...)
+    a.addField(s.tree, n, n.String(), v)
+    return v
+  case *parse.VariableNode:
+    base, found := s.vars[n.Ident[0]]
+    if !found {
+      base = unknown
+    }
+    if len(n.Ident) == 1 {
+      return base
+    }
+    v := base.child(n.Ident[1:]...)
+    a.addField(s.tree, n, n.String(), v)
+    return v
+  case *parse.ChainNode:
+    base := a.arg(n.Node, dot, s)
+    v := base.child(n.Field...)
+    a.addField(s.tree, n, n.String(), v)
+    return v
+  case *parse.PipeNode:
+    return a.pipe(n, dot, s.with(nil))
+  }
+  return unknown
+}
+
+func (a *analyzer) addField(tree *parse.Tree, node parse.Node, chain string, v value) {
+  loc := a.location(tree, node)
+  key := "field:" + loc.String() + ":" + chain + ":" + v.String()
+  if a.seen[key] {
+    return
+  }
+  a.seen[key] = true
+
+  a.report.Fields = append(a.report.Fields, &Field{
+    Location: loc,
+    Chain:    chain,
+    Path:     v.String(),
+  })
+}
+
+// location returns the position of node in tree's source.
+func (a *analyzer) location(tree *parse.Tree, node parse.Node) Location {
+  loc := Location{Template: tree.ParseName}
+  location, _ := tree.ErrorContext(node)
+  parts := strings.Split(location, ":")
+  if len(parts) >= 3 {
+    loc.Line, _ = strconv.Atoi(parts[len(parts)-2])
+    loc.Column, _ = strconv.Atoi(parts[len(parts)-1])
+  }
+  return loc
+}
+
+// literalKind returns the kind of a constant argument, or "" if node is not
+// a constant.
+func literalKind(node parse.Node) string {
+  switch node.(type) {
+  case *parse.StringNode:
+    return "string"
+  case *parse.NumberNode:
+    return "number"
+  case *parse.BoolNode:
+    return "bool"
+  case *parse.NilNode:
+    return "nil"
+  }
+  return ""
+}
+
+// splitKey splits a where key such as "Params.author" into its parts.
+func splitKey(key string) []string {
+  return strings.Split(strings.Trim(key, "."), ".")
+}
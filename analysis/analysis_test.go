@@ -0,0 +1,102 @@
+package analysis
+
+import (
+  "fmt"
+  "testing"
+  "text/template/parse"
+)
+
+// analyze parses tmpl as "preview" and analyzes it.
+func analyze(t *testing.T, tmpl string) *Report {
+  t.Helper()
+  trees := make(map[string]*parse.Tree)
+  if err := Parse(trees, "preview", tmpl, "", ""); err != nil {
+    t.Fatal(err)
+  }
+  return Analyze(trees, "preview")
+}
+
+func TestString(t *testing.T) {
+  report := analyze(t, `{{ range $post := .posts }}{{ $post.title }}{{ end }}{{ partial "nav.html" . }}{{ where .posts "draft" false }}`)
+
+  tests := []struct {
+    value fmt.Stringer
+    want  string
+  }{
+    {report.Fields[0], "preview:1:18: .posts (posts)"},
+    {*report.Fields[1], "preview:1:35: $post.title (posts.*.title)"},
+    {report.Functions[0], "preview:1:56: partial/2"},
+    {report.Partials[0], `preview:1:56: partial "nav.html" (not found)`},
+    {&Problem{Location: Location{"preview", 2, 4}, Rule: RuleArity, Message: "first expects 2 arguments, got 1"}, "preview:2:4: first expects 2 arguments, got 1 (arity)"},
+  }
+
+  for _, test := range tests {
+    for _, got := range []string{fmt.Sprint(test.value), fmt.Sprintf("%v", test.value), fmt.Sprintf("%+v", test.value)} {
+      if got != test.want {
+        t.Errorf("got %q, want %q", got, test.want)
+      }
+    }
+  }
+}
+
+func TestWhere(t *testing.T) {
+  tests := []struct {
+    tmpl  string
+    chain string
+    path  string
+  }{
+    {`{{ where .posts "section" "news" }}`, `"section"`, "posts.*.section"},
+    {`{{ where .posts "author.name" "ne" "Jo" }}`, `"author.name"`, "posts.*.author.name"},
+    {`{{ .posts | where "section" "news" }}`, `"section"`, "posts.*.section"},
+    {`{{ .posts | where "draft" "ne" true }}`, `"draft"`, "posts.*.draft"},
+    {`{{ range .posts | where "section" "news" }}{{ .title }}{{ end }}`, `"section"`, "posts.*.section"},
+  }
+
+  for _, test := range tests {
+    report := analyze(t, test.tmpl)
+    found := false
+    for _, f := range report.Fields {
+      if f.Chain == test.chain {
+        found = true
+        if f.Path != test.path {
+          t.Errorf("%s: got path %q, want %q", test.tmpl, f.Path, test.path)
+        }
+      }
+    }
+    if !found {
+      t.Errorf("%s: no field %s in %v", test.tmpl, test.chain, report.Fields)
+    }
+  }
+}
+
+func TestLintWhere(t *testing.T) {
+  schema, err := ParseSchema([]byte(`[{"name": "posts", "widget": "list", "fields": [{"name": "draft", "widget": "boolean"}, {"name": "title", "widget": "string"}]}]`))
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  tests := []struct {
+    tmpl     string
+    problems []string
+  }{
+    {`{{ where .posts "draft" true }}`, nil},
+    {`{{ .posts | where "draft" true }}`, nil},
+    {`{{ .posts | where "draft" "yes" }}`, []string{`where compares "draft" (bool) with "yes" (string)`}},
+    {`{{ .posts | where "draft" "!=" "yes" }}`, []string{`where compares "draft" (bool) with "yes" (string)`}},
+    {`{{ .posts | where "nope" 1 }}`, []string{`"nope": no field "nope" in "posts.*"`}},
+  }
+
+  for _, test := range tests {
+    trees := make(map[string]*parse.Tree)
+    if err := Parse(trees, "preview", test.tmpl, "", ""); err != nil {
+      t.Fatal(err)
+    }
+    var got []string
+    for _, p := range Lint(trees, "preview", schema, map[string]interface{}{"where": nil}) {
+      got = append(got, p.Message)
+    }
+    if fmt.Sprint(got) != fmt.Sprint(test.problems) {
+      t.Errorf("%s: got %q, want %q", test.tmpl, got, test.problems)
+    }
+  }
+}
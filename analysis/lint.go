@@ -0,0 +1,207 @@
+package analysis
+
+import (
+  "fmt"
+  "sort"
+  "strconv"
+  "strings"
+  "text/template/parse"
+)
+
+// Lint rules, as reported in Problem.Rule.
+const (
+  RuleUnknownFunction = "unknown-function"
+  RuleUnknownField    = "unknown-field"
+  RuleArity           = "arity"
+  RuleTypeMismatch    = "type-mismatch"
+)
+
+// builtins are the functions text/template defines itself.
+var builtins = map[string]bool{
+  "and": true, "call": true, "html": true, "index": true, "js": true,
+  "len": true, "not": true, "or": true, "print": true, "printf": true,
+  "println": true, "slice": true, "urlquery": true,
+  "eq": true, "ge": true, "gt": true, "le": true, "lt": true, "ne": true,
+}
+
+// IsBuiltin reports whether name is one of the functions text/template
+// defines itself, such as len, index or eq.
+func IsBuiltin(name string) bool {
+  return builtins[name]
+}
+
+// comparisons are the builtins comparing their arguments.
+var comparisons = map[string]bool{
+  "eq": true, "ne": true, "lt": true, "le": true, "gt": true, "ge": true,
+}
+
+// Problem is a likely mistake found by Lint.
+type Problem struct {
+  Location
+  Rule    string
+  Message string
+}
+
+// Lint walks the named template of trees and the templates and partials it
+// calls, reporting calls to functions missing from funcs and the text/template
+// builtins, fields that are not in schema, calls to where, first and dict
+// with the wrong number of arguments and comparisons between values of
+// different types. If schema is nil, fields are not checked.
+func Lint(trees map[string]*parse.Tree, name string, schema *Schema, funcs map[string]interface{}) []*Problem {
+  l := &linter{schema: schema, funcs: funcs}
+  report := Analyze(trees, name)
+
+  for _, f := range report.Functions {
+    l.function(f)
+  }
+  if schema != nil {
+    for _, f := range report.Fields {
+      l.field(f)
+    }
+  }
+
+  sort.SliceStable(l.problems, func(i, j int) bool {
+    return l.problems[i].Location.less(l.problems[j].Location)
+  })
+  return l.problems
+}
+
+type linter struct {
+  schema   *Schema
+  funcs    map[string]interface{}
+  problems []*Problem
+}
+
+// String returns the location, message and rule of the problem, e.g.
+// `preview:1:3: function "foo" not defined (unknown-function)`.
+func (p Problem) String() string {
+  return p.Location.String() + ": " + p.Message + " (" + p.Rule + ")"
+}
+
+func (l *linter) report(loc Location, rule, format string, args ...interface{}) {
+  l.problems = append(l.problems, &Problem{
+    Location: loc,
+    Rule:     rule,
+    Message:  fmt.Sprintf(format, args...),
+  })
+}
+
+func (l *linter) function(f *Function) {
+  if !l.isFunc(f.Name) {
+    l.report(f.Location, RuleUnknownFunction, "function %q not defined", f.Name)
+    return
+  }
+
+  switch f.Name {
+  case "where":
+    if f.Args != 3 && f.Args != 4 {
+      l.report(f.Location, RuleArity, "where expects 3 or 4 arguments, got %d", f.Args)
+      return
+    }
+    l.where(f)
+  case "first":
+    if f.Args != 2 {
+      l.report(f.Location, RuleArity, "first expects 2 arguments, got %d", f.Args)
+    }
+  case "dict":
+    if f.Args%2 != 0 {
+      l.report(f.Location, RuleArity, "dict expects an even number of arguments, got %d", f.Args)
+    }
+  }
+
+  if comparisons[f.Name] && len(f.Operands) >= 2 {
+    first := f.Operands[0]
+    for _, o := range f.Operands[1:] {
+      l.compare(f.Location, f.Name, first, l.kind(first), o, l.kind(o))
+    }
+  }
+}
+
+func (l *linter) isFunc(name string) bool {
+  _, found := l.funcs[name]
+  return found || builtins[name]
+}
+
+// where checks that the value matched by a call to where has the type of
+// the key it is matched against.
+func (l *linter) where(f *Function) {
+  // where list "key" [op] match, or list | where "key" [op] match, where
+  // the list is piped in last.
+  operands := f.Operands
+  if last := operands[len(operands)-1]; last.Text == "" {
+    operands = append([]Operand{last}, operands[:len(operands)-1]...)
+  }
+  list, key, match := operands[0], operands[1], operands[len(operands)-1]
+  if l.schema == nil || list.Path == "" || key.Literal != "string" {
+    return
+  }
+  if len(operands) == 4 {
+    op, err := strconv.Unquote(operands[2].Text)
+    if err != nil || op == "in" || op == "not in" || op == "intersect" {
+      return
+    }
+  }
+
+  name, err := strconv.Unquote(key.Text)
+  if err != nil {
+    return
+  }
+  path := strings.Split(list.Path, ".")
+  path = append(append(path, "*"), splitKey(name)...)
+  field, _ := l.schema.resolve(path)
+  if field != nil {
+    l.compare(f.Location, "where", key, field.kind(), match, l.kind(match))
+  }
+}
+
+// compare reports a mismatch between the types of two operands, if both are
+// known.
+func (l *linter) compare(loc Location, fn string, a Operand, aKind string, b Operand, bKind string) {
+  if aKind == "" || bKind == "" || aKind == bKind {
+    return
+  }
+  l.report(loc, RuleTypeMismatch, "%s compares %s (%s) with %s (%s)",
+    fn, operandText(a), aKind, operandText(b), bKind)
+}
+
+// kind returns the type of o, from its literal or the schema, or "" if it
+// is not known.
+func (l *linter) kind(o Operand) string {
+  switch o.Literal {
+  case "nil":
+    return ""
+  case "":
+  default:
+    return o.Literal
+  }
+  if l.schema == nil || o.Path == "" {
+    return ""
+  }
+  if field, _ := l.schema.resolve(strings.Split(o.Path, ".")); field != nil {
+    return field.kind()
+  }
+  return ""
+}
+
+func operandText(o Operand) string {
+  if o.Text == "" {
+    return "piped value"
+  }
+  return o.Text
+}
+
+// field reports a field whose path leaves the schema.
+func (l *linter) field(f *Field) {
+  if f.Path == "" {
+    return
+  }
+  path := strings.Split(f.Path, ".")
+  if _, n := l.schema.resolve(path); n < len(path) {
+    parent := strings.Join(path[:n], ".")
+    if parent == "" {
+      l.report(f.Location, RuleUnknownField, "%s: no field %q in the collection", f.Chain, path[n])
+    } else {
+      l.report(f.Location, RuleUnknownField, "%s: no field %q in %q", f.Chain, path[n], parent)
+    }
+  }
+}
@@ -0,0 +1,163 @@
+package analysis
+
+import (
+  "encoding/json"
+  "strings"
+)
+
+// Schema describes the data of an entry as configured for a Netlify CMS
+// collection.
+type Schema struct {
+  Fields []*SchemaField `json:"fields"`
+}
+
+// SchemaField is a single field of a collection, as written in the CMS
+// config. Object and list widgets nest further fields.
+type SchemaField struct {
+  Name   string `json:"name"`
+  Widget string `json:"widget"`
+
+  // Fields are the fields of an object, or of each element of a list.
+  Fields []*SchemaField `json:"fields"`
+
+  // Field is the single field making up each element of a list.
+  Field *SchemaField `json:"field"`
+
+  // Types are the object types a list may mix, told apart by TypeKey.
+  Types   []*SchemaField `json:"types"`
+  TypeKey string         `json:"typeKey"`
+
+  // Multiple is set on select and relation widgets holding a list of
+  // values.
+  Multiple bool `json:"multiple"`
+}
+
+// ParseSchema reads a schema from the JSON form of either a collection, with
+// its fields under "fields", or the array of fields itself.
+func ParseSchema(data []byte) (*Schema, error) {
+  if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "[") {
+    var fields []*SchemaField
+    if err := json.Unmarshal(data, &fields); err != nil {
+      return nil, err
+    }
+    return &Schema{Fields: fields}, nil
+  }
+
+  var s Schema
+  if err := json.Unmarshal(data, &s); err != nil {
+    return nil, err
+  }
+  return &s, nil
+}
+
+// root returns the object holding the top level fields of s.
+func (s *Schema) root() *SchemaField {
+  return &SchemaField{Widget: "object", Fields: s.Fields}
+}
+
+// child returns the field reached from f by name, "*" standing for an element
+// of a list. found is false if f cannot have such a child. A nil field with
+// found set means the schema does not say what the child looks like.
+func (f *SchemaField) child(name string) (c *SchemaField, found bool) {
+  switch f.widget() {
+  case "object":
+    if name == "*" {
+      return nil, true
+    }
+    for _, field := range f.Fields {
+      if field.Name == name {
+        return field, true
+      }
+    }
+    return nil, false
+  case "list":
+    if name != "*" {
+      return nil, false
+    }
+    return f.elem(), true
+  case "select", "relation":
+    if f.Multiple && name == "*" {
+      return &SchemaField{Widget: "string"}, true
+    }
+    return nil, false
+  case "string", "text", "markdown", "number", "boolean", "date", "datetime",
+    "image", "file", "color", "map":
+    return nil, false
+  }
+  // Custom widgets and the likes of hidden and code may hold anything.
+  return nil, true
+}
+
+// elem returns the field describing each element of a list.
+func (f *SchemaField) elem() *SchemaField {
+  switch {
+  case f.Field != nil:
+    return f.Field
+  case len(f.Fields) > 0:
+    return &SchemaField{Widget: "object", Fields: f.Fields}
+  case len(f.Types) > 0:
+    typeKey := f.TypeKey
+    if typeKey == "" {
+      typeKey = "type"
+    }
+    fields := []*SchemaField{{Name: typeKey, Widget: "string"}}
+    for _, t := range f.Types {
+      fields = append(fields, t.Fields...)
+    }
+    return &SchemaField{Widget: "object", Fields: fields}
+  }
+  // A list without fields holds strings.
+  return &SchemaField{Widget: "string"}
+}
+
+// widget returns f's widget, which defaults to "string" in the CMS.
+func (f *SchemaField) widget() string {
+  if f.Widget == "" && len(f.Fields) > 0 {
+    return "object"
+  }
+  if f.Widget == "" {
+    return "string"
+  }
+  return f.Widget
+}
+
+// kind returns the type of value f holds, as one of "string", "number",
+// "bool", "list" or "object", or "" if it may vary.
+func (f *SchemaField) kind() string {
+  switch f.widget() {
+  case "number":
+    return "number"
+  case "boolean":
+    return "bool"
+  case "list":
+    return "list"
+  case "object":
+    return "object"
+  case "select", "relation":
+    if f.Multiple {
+      return "list"
+    }
+    return "string"
+  case "string", "text", "markdown", "image", "file", "color", "map":
+    return "string"
+  }
+  return ""
+}
+
+// resolve follows path from the top level of s. It returns the field at the
+// end of the path, nil if the schema does not describe it, and the number of
+// elements of path that were found.
+func (s *Schema) resolve(path []string) (f *SchemaField, n int) {
+  f = s.root()
+  for i, name := range path {
+    c, found := f.child(name)
+    if !found {
+      return nil, i
+    }
+    if c == nil {
+      return nil, len(path)
+    }
+    f = c
+  }
+  return f, len(path)
+}
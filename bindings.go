@@ -0,0 +1,203 @@
+package main
+
+import (
+  "context"
+  "encoding/json"
+  "math"
+  "time"
+
+  "github.com/erquhart/netlify-cms-template-parser-go/analysis"
+  "github.com/erquhart/netlify-cms-template-parser-go/hugo/output"
+  "github.com/erquhart/netlify-cms-template-parser-go/render"
+)
+
+// The functions below implement the parts of the JS API shared by the
+// GopherJS and WebAssembly builds. They take arguments already converted to
+// Go values the way GopherJS converts them: objects become
+// map[string]interface{}, arrays []interface{} and numbers float64. Missing
+// arguments are nil.
+
+// newEnvironment returns the environment described by options, an object of
+// the form { config }; see createEnvironment in main.go.
+func newEnvironment(options interface{}) *render.Environment {
+  o, _ := options.(map[string]interface{})
+  values, _ := o["config"].(map[string]interface{})
+  return render.NewEnvironment(values)
+}
+
+// optionsFromValue reads render options from an object of the form
+// { base, name, baseName, format, delims: [left, right], missingKey,
+//   sourceMap, persistScratch,
+//   limits: { timeout, steps, partialDepth, outputBytes } },
+// with the timeout in milliseconds. Missing properties keep their defaults.
+// Unless persistScratch is set, each render gets a fresh scratch rather than
+// the exported one.
+func optionsFromValue(v interface{}) render.Options {
+  var opts render.Options
+  o, ok := v.(map[string]interface{})
+  if !ok {
+    return opts
+  }
+
+  fields := map[string]*string{
+    "base":       &opts.Base,
+    "name":       &opts.Name,
+    "baseName":   &opts.BaseName,
+    "format":     &opts.Format,
+    "missingKey": &opts.MissingKey,
+  }
+  for key, field := range fields {
+    if s, ok := o[key].(string); ok {
+      *field = s
+    }
+  }
+
+  opts.SourceMap = truthy(o["sourceMap"])
+  opts.PersistScratch = truthy(o["persistScratch"])
+
+  if delims, ok := o["delims"].([]interface{}); ok && len(delims) == 2 {
+    opts.LeftDelim, _ = delims[0].(string)
+    opts.RightDelim, _ = delims[1].(string)
+  }
+
+  if limits, ok := o["limits"].(map[string]interface{}); ok {
+    if ms, ok := limits["timeout"].(float64); ok {
+      opts.Limits.Timeout = time.Duration(ms * float64(time.Millisecond))
+    }
+    ints := map[string]*int{
+      "steps":        &opts.Limits.MaxSteps,
+      "partialDepth": &opts.Limits.MaxPartialDepth,
+      "outputBytes":  &opts.Limits.MaxOutputBytes,
+    }
+    for key, field := range ints {
+      if n, ok := limits[key].(float64); ok {
+        *field = int(n)
+      }
+    }
+  }
+
+  return opts
+}
+
+// chunkSizeFromValue reads the chunkSize property of the options of a
+// stream, the number of bytes buffered before a chunk is delivered.
+func chunkSizeFromValue(v interface{}) int {
+  o, _ := v.(map[string]interface{})
+  size, _ := o["chunkSize"].(float64)
+  return int(size)
+}
+
+// descriptorFromValue reads a layout descriptor from an object of the form
+// { kind, type, section, layout }. kind defaults to "page".
+func descriptorFromValue(v interface{}) output.LayoutDescriptor {
+  d := output.LayoutDescriptor{Kind: output.KindPage}
+  o, ok := v.(map[string]interface{})
+  if !ok {
+    return d
+  }
+
+  fields := map[string]*string{
+    "kind":    &d.Kind,
+    "type":    &d.Type,
+    "section": &d.Section,
+    "layout":  &d.Layout,
+  }
+  for key, field := range fields {
+    if s, ok := o[key].(string); ok {
+      *field = s
+    }
+  }
+
+  return d
+}
+
+// schemaFromValue reads a collection schema from an object or JSON string.
+func schemaFromValue(v interface{}) (*analysis.Schema, error) {
+  if v == nil {
+    return nil, nil
+  }
+  if text, ok := v.(string); ok {
+    return analysis.ParseSchema([]byte(text))
+  }
+  data, err := json.Marshal(v)
+  if err != nil {
+    return nil, err
+  }
+  return analysis.ParseSchema(data)
+}
+
+// analyzeToMap returns the fields, functions and partials tmpl refers to;
+// see analyze in main.go.
+func analyzeToMap(env *render.Environment, tmpl string, opts render.Options) map[string]interface{} {
+  report, err := env.Analyze(tmpl, opts)
+  if err != nil {
+    return map[string]interface{}{"errors": errorToMap(err)}
+  }
+
+  m := reportToMap(report)
+  m["errors"] = []interface{}{}
+  return m
+}
+
+// lintToMap checks tmpl against schema and the functions available to
+// templates; see lint in main.go.
+func lintToMap(env *render.Environment, tmpl string, schema interface{}, opts render.Options) map[string]interface{} {
+  s, err := schemaFromValue(schema)
+  if err != nil {
+    return map[string]interface{}{"problems": []interface{}{}, "errors": errorToMap(err)}
+  }
+
+  problems, err := env.Lint(tmpl, s, opts)
+  errs := []interface{}{}
+  if err != nil {
+    errs = errorToMap(err)
+  }
+  return map[string]interface{}{"problems": problemsToMap(problems), "errors": errs}
+}
+
+// resolveLayoutToMap returns the names of the layout and base template Hugo
+// would choose for d in format, along with an error message if there is no
+// matching layout.
+func resolveLayoutToMap(env *render.Environment, d output.LayoutDescriptor, format string) map[string]interface{} {
+  layout, base, err := env.ResolveLayout(d, format)
+  result := map[string]interface{}{
+    "layout": layout,
+    "base":   base,
+  }
+  if err != nil {
+    result["error"] = err.Error()
+  }
+  return result
+}
+
+// renderStream renders p against data, passing the output to deliver in
+// chunks of size bytes, and returns the result once the render is done or
+// ctx is canceled.
+func renderStream(ctx context.Context, p *render.Prepared, data interface{}, size int, deliver func(chunk string) error) map[string]interface{} {
+  w := render.NewChunkWriter(size, func(chunk []byte) error {
+    return deliver(string(chunk))
+  })
+  result, _ := p.RenderTo(ctx, w, data)
+  if ctx.Err() == nil {
+    if err := w.Flush(); err != nil {
+      result.Errors = append(result.Errors, render.NewTemplateError(err))
+    }
+  }
+  return resultToMap(result)
+}
+
+// truthy reports whether v, a converted JS value, is truthy in JS.
+func truthy(v interface{}) bool {
+  switch v := v.(type) {
+  case nil:
+    return false
+  case bool:
+    return v
+  case float64:
+    return v != 0 && !math.IsNaN(v)
+  case string:
+    return v != ""
+  default:
+    return true
+  }
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "path/filepath"
+  "strings"
+  "time"
+
+  "github.com/BurntSushi/toml"
+  "gopkg.in/yaml.v2"
+)
+
+// bodyField is the field the content following the front matter of an entry
+// is stored under, as in the entries Netlify CMS passes to previews.
+const bodyField = "body"
+
+// readData reads the entry data in the file at path. JSON, YAML and TOML
+// files are decoded whole; any other file, such as a Markdown entry, is read
+// as front matter followed by a body.
+func readData(path string) (map[string]interface{}, error) {
+  src, err := ioutil.ReadFile(path)
+  if err != nil {
+    return nil, err
+  }
+
+  var data map[string]interface{}
+  switch strings.ToLower(filepath.Ext(path)) {
+  case ".json":
+    err = decode(src, "json", &data)
+  case ".yaml", ".yml":
+    err = decode(src, "yaml", &data)
+  case ".toml":
+    err = decode(src, "toml", &data)
+  default:
+    data, err = parseEntry(src)
+  }
+  if err != nil {
+    return nil, fmt.Errorf("%s: %v", path, err)
+  }
+  return data, nil
+}
+
+// parseEntry splits src into its front matter, delimited by "---" for YAML,
+// "+++" for TOML or braces for JSON, and body. An entry without front matter
+// is all body.
+func parseEntry(src []byte) (map[string]interface{}, error) {
+  data := map[string]interface{}{}
+  front, body, format := splitFrontMatter(src)
+  if format != "" {
+    if err := decode(front, format, &data); err != nil {
+      return nil, fmt.Errorf("front matter: %v", err)
+    }
+  }
+  data[bodyField] = string(body)
+  return data, nil
+}
+
+// splitFrontMatter returns the front matter of src, the rest of it and the
+// format of the front matter, which is empty if there is none.
+func splitFrontMatter(src []byte) (front, body []byte, format string) {
+  src = bytes.TrimPrefix(src, []byte("\ufeff"))
+
+  if bytes.HasPrefix(src, []byte("{")) {
+    dec := json.NewDecoder(bytes.NewReader(src))
+    var raw json.RawMessage
+    if err := dec.Decode(&raw); err != nil {
+      return nil, src, ""
+    }
+    return raw, trimLeadingNewline(src[dec.InputOffset():]), "json"
+  }
+
+  first, rest := cutLine(src)
+  for _, f := range []struct{ delim, format string }{{"---", "yaml"}, {"+++", "toml"}} {
+    if strings.TrimSpace(string(first)) != f.delim {
+      continue
+    }
+    for start := rest; len(rest) > 0; {
+      line, next := cutLine(rest)
+      if strings.TrimSpace(string(line)) == f.delim {
+        return start[:len(start)-len(rest)], next, f.format
+      }
+      rest = next
+    }
+  }
+
+  return nil, src, ""
+}
+
+// cutLine returns the first line of b, without its line ending, and what
+// follows it.
+func cutLine(b []byte) (line, rest []byte) {
+  i := bytes.IndexByte(b, '\n')
+  if i < 0 {
+    return b, nil
+  }
+  return bytes.TrimSuffix(b[:i], []byte("\r")), b[i+1:]
+}
+
+func trimLeadingNewline(b []byte) []byte {
+  b = bytes.TrimPrefix(b, []byte("\r"))
+  return bytes.TrimPrefix(b, []byte("\n"))
+}
+
+// decode decodes src in the given format into data, normalizing the values
+// the way they would arrive from the CMS.
+func decode(src []byte, format string, data *map[string]interface{}) error {
+  var v interface{}
+  var err error
+  switch format {
+  case "json":
+    err = json.Unmarshal(src, &v)
+  case "yaml":
+    err = yaml.Unmarshal(src, &v)
+  case "toml":
+    var m map[string]interface{}
+    _, err = toml.Decode(string(src), &m)
+    v = m
+  default:
+    err = fmt.Errorf("unknown format %q", format)
+  }
+  if err != nil {
+    return err
+  }
+
+  switch m := normalize(v).(type) {
+  case map[string]interface{}:
+    *data = m
+  case nil:
+    *data = map[string]interface{}{}
+  default:
+    return fmt.Errorf("expected an object, got %T", m)
+  }
+  return nil
+}
+
+// normalize converts v to the types values have once passed from JS to
+// the parser: maps are keyed by strings, numbers are float64 and dates are
+// strings, so that templates behave as they do in the CMS preview.
+func normalize(v interface{}) interface{} {
+  switch v := v.(type) {
+  case map[interface{}]interface{}:
+    m := make(map[string]interface{}, len(v))
+    for key, value := range v {
+      m[fmt.Sprint(key)] = normalize(value)
+    }
+    return m
+  case map[string]interface{}:
+    m := make(map[string]interface{}, len(v))
+    for key, value := range v {
+      m[key] = normalize(value)
+    }
+    return m
+  case []map[string]interface{}:
+    s := make([]interface{}, len(v))
+    for i, value := range v {
+      s[i] = normalize(value)
+    }
+    return s
+  case []interface{}:
+    s := make([]interface{}, len(v))
+    for i, value := range v {
+      s[i] = normalize(value)
+    }
+    return s
+  case int:
+    return float64(v)
+  case int64:
+    return float64(v)
+  case uint64:
+    return float64(v)
+  case float32:
+    return float64(v)
+  case time.Time:
+    return v.Format(time.RFC3339)
+  default:
+    return v
+  }
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+  "reflect"
+  "testing"
+  "time"
+)
+
+func TestSplitFrontMatter(t *testing.T) {
+  tests := []struct {
+    name   string
+    src    string
+    front  string
+    body   string
+    format string
+  }{
+    {"yaml", "---\ntitle: a\n---\nbody\n", "title: a\n", "body\n", "yaml"},
+    {"yaml with crlf", "---\r\ntitle: a\r\n---\r\nbody", "title: a\r\n", "body", "yaml"},
+    {"toml", "+++\ntitle = \"a\"\n+++\nbody", "title = \"a\"\n", "body", "toml"},
+    {"json", "{\"title\": \"a\"}\nbody", `{"title": "a"}`, "body", "json"},
+    {"byte order mark", "\ufeff---\ntitle: a\n---\nbody", "title: a\n", "body", "yaml"},
+    {"none", "# Title\n\nbody", "", "# Title\n\nbody", ""},
+    {"empty", "", "", "", ""},
+    {"unterminated yaml", "---\ntitle: a\nbody", "", "---\ntitle: a\nbody", ""},
+    {"unterminated toml", "+++\ntitle = \"a\"\n", "", "+++\ntitle = \"a\"\n", ""},
+    {"unterminated json", "{\"title\": \"a\"\nbody", "", "{\"title\": \"a\"\nbody", ""},
+  }
+
+  for _, test := range tests {
+    front, body, format := splitFrontMatter([]byte(test.src))
+    if string(front) != test.front || string(body) != test.body || format != test.format {
+      t.Errorf("%s: got %q, %q, %q, want %q, %q, %q", test.name, front, body, format, test.front, test.body, test.format)
+    }
+  }
+}
+
+func TestParseEntry(t *testing.T) {
+  tests := []struct {
+    name string
+    src  string
+    want map[string]interface{}
+    err  bool
+  }{
+    {"yaml", "---\ntitle: a\ncount: 2\n---\nbody", map[string]interface{}{"title": "a", "count": 2.0, "body": "body"}, false},
+    {"toml", "+++\ntitle = \"a\"\n+++\nbody", map[string]interface{}{"title": "a", "body": "body"}, false},
+    {"json", "{\"title\": \"a\"}\nbody", map[string]interface{}{"title": "a", "body": "body"}, false},
+    {"empty front matter", "---\n---\nbody", map[string]interface{}{"body": "body"}, false},
+    {"none", "body", map[string]interface{}{"body": "body"}, false},
+    {"invalid yaml", "---\n: a: b\n---\n", nil, true},
+    {"not an object", "---\n- a\n---\n", nil, true},
+  }
+
+  for _, test := range tests {
+    got, err := parseEntry([]byte(test.src))
+    if (err != nil) != test.err {
+      t.Errorf("%s: got error %v, want error %v", test.name, err, test.err)
+      continue
+    }
+    if !test.err && !reflect.DeepEqual(got, test.want) {
+      t.Errorf("%s: got %#v, want %#v", test.name, got, test.want)
+    }
+  }
+}
+
+func TestNormalize(t *testing.T) {
+  date := time.Date(2017, 3, 4, 5, 6, 7, 0, time.UTC)
+
+  tests := []struct {
+    name string
+    in   interface{}
+    want interface{}
+  }{
+    {"int", 1, 1.0},
+    {"int64", int64(2), 2.0},
+    {"uint64", uint64(3), 3.0},
+    {"float32", float32(1.5), 1.5},
+    {"float64", 2.5, 2.5},
+    {"string", "a", "a"},
+    {"bool", true, true},
+    {"nil", nil, nil},
+    {"time", date, "2017-03-04T05:06:07Z"},
+    {"yaml map", map[interface{}]interface{}{"a": 1, 2: "b"}, map[string]interface{}{"a": 1.0, "2": "b"}},
+    {"nested", map[string]interface{}{"list": []interface{}{map[interface{}]interface{}{"n": int64(1)}}}, map[string]interface{}{"list": []interface{}{map[string]interface{}{"n": 1.0}}}},
+    {"toml array of tables", []map[string]interface{}{{"n": int64(1)}}, []interface{}{map[string]interface{}{"n": 1.0}}},
+  }
+
+  for _, test := range tests {
+    if got := normalize(test.in); !reflect.DeepEqual(got, test.want) {
+      t.Errorf("%s: got %#v, want %#v", test.name, got, test.want)
+    }
+  }
+}
@@ -0,0 +1,162 @@
+// Command tplrender renders a preview template against entry data from the
+// terminal, through the same renderer and functions as the CMS preview.
+//
+// Usage:
+//
+//   tplrender [flags] template data
+//   tplrender serve [flags]
+//
+// data is a JSON, YAML or TOML file, or an entry such as a Markdown file
+// with front matter, whose body is available to the template as .body. The
+// output is printed to stdout; errors and missing keys to stderr.
+//
+// The serve command serves a preview of each entry in a directory, rendered
+// through the layout Hugo would choose for it, and reloads it in the browser
+// whenever an entry, layout or the config changes.
+package main
+
+import (
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+
+  "github.com/erquhart/netlify-cms-template-parser-go/render"
+)
+
+func main() {
+  if len(os.Args) > 1 && os.Args[1] == "serve" {
+    serve(os.Args[2:])
+    return
+  }
+
+  flag.Usage = func() {
+    fmt.Fprintln(flag.CommandLine.Output(), "usage: tplrender [flags] template data\n       tplrender serve [flags]")
+    flag.PrintDefaults()
+  }
+  var (
+    base       = flag.String("base", "", "base template `file`, such as a baseof.html")
+    format     = flag.String("format", "", "Hugo output `format`, such as HTML, JSON or RSS (default HTML)")
+    layouts    = flag.String("layouts", "", "Hugo layouts `dir` whose partials are made available")
+    configFile = flag.String("config", "", "site config `file` read by the site function")
+    missingKey = flag.String("missingkey", "", "missing map key `behavior`: default, zero or error")
+  )
+  flag.Parse()
+  if flag.NArg() != 2 {
+    flag.Usage()
+    os.Exit(2)
+  }
+
+  env, err := newEnvironment(*configFile, *layouts)
+  if err != nil {
+    fatal(err)
+  }
+
+  opts := render.Options{Format: *format, MissingKey: *missingKey}
+  tmpl, err := readTemplate(flag.Arg(0), &opts.Name)
+  if err != nil {
+    fatal(err)
+  }
+  if *base != "" {
+    if opts.Base, err = readTemplate(*base, &opts.BaseName); err != nil {
+      fatal(err)
+    }
+  }
+
+  data, err := readData(flag.Arg(1))
+  if err != nil {
+    fatal(err)
+  }
+
+  result, err := env.Render(data, tmpl, opts)
+  os.Stdout.WriteString(result.HTML)
+  report(result)
+  if err != nil {
+    os.Exit(1)
+  }
+}
+
+// newEnvironment returns an environment configured with the site config in
+// configFile, if any, and the files of the layouts directory, if any.
+func newEnvironment(configFile, layouts string) (*render.Environment, error) {
+  var config map[string]interface{}
+  if configFile != "" {
+    var err error
+    if config, err = readData(configFile); err != nil {
+      return nil, err
+    }
+  }
+
+  env := render.NewEnvironment(config)
+  if layouts != "" {
+    if err := registerLayouts(env, layouts); err != nil {
+      return nil, err
+    }
+  }
+  return env, nil
+}
+
+// registerLayouts registers every file under dir as a layout, named by its
+// path relative to dir.
+func registerLayouts(env *render.Environment, dir string) error {
+  return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+    if err != nil || info.IsDir() {
+      return err
+    }
+    name, err := filepath.Rel(dir, path)
+    if err != nil {
+      return err
+    }
+    source, err := ioutil.ReadFile(path)
+    if err != nil {
+      return err
+    }
+    env.RegisterLayout(filepath.ToSlash(name), string(source))
+    return nil
+  })
+}
+
+// readTemplate returns the source of the template in the file at path and
+// sets name to the file's base name, as reported in errors.
+func readTemplate(path string, name *string) (string, error) {
+  source, err := ioutil.ReadFile(path)
+  if err != nil {
+    return "", err
+  }
+  *name = filepath.Base(path)
+  return string(source), nil
+}
+
+// report prints the errors and missing keys of result to stderr.
+func report(result render.Result) {
+  for _, e := range result.Errors {
+    fmt.Fprintf(os.Stderr, "%s: %s\n", position(e.Name, e.Line, e.Column), e.Message)
+  }
+  for _, k := range result.MissingKeys {
+    fmt.Fprintf(os.Stderr, "%s: missing key %s\n", position(k.Name, k.Line, k.Column), k.Key)
+  }
+  if result.MissingKeysTruncated {
+    fmt.Fprintln(os.Stderr, "tplrender: template too large to list every missing key")
+  }
+}
+
+// position formats a template position as name:line:column, leaving out
+// what is unknown.
+func position(name string, line, column int) string {
+  switch {
+  case name == "":
+    return "tplrender"
+  case line == 0:
+    return name
+  case column == 0:
+    return fmt.Sprintf("%s:%d", name, line)
+  default:
+    return fmt.Sprintf("%s:%d:%d", name, line, column)
+  }
+}
+
+func fatal(err error) {
+  fmt.Fprintln(os.Stderr, "tplrender:", err)
+  os.Exit(1)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+  "bytes"
+  "os"
+  "os/exec"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+// When tplrenderArgs is set, the test binary runs main with the arguments
+// it holds, separated by newlines, so that tests can check how the command
+// exits.
+const tplrenderArgs = "TPLRENDER_ARGS"
+
+func TestMain(m *testing.M) {
+  if args := os.Getenv(tplrenderArgs); args != "" {
+    os.Args = append([]string{"tplrender"}, strings.Split(args, "\n")...)
+    main()
+    os.Exit(0)
+  }
+  os.Exit(m.Run())
+}
+
+// run runs tplrender with args, returning its stdout, stderr and exit code.
+func run(t *testing.T, args ...string) (stdout, stderr string, code int) {
+  cmd := exec.Command(os.Args[0])
+  cmd.Env = append(os.Environ(), tplrenderArgs+"="+strings.Join(args, "\n"))
+  var out, errOut bytes.Buffer
+  cmd.Stdout, cmd.Stderr = &out, &errOut
+  err := cmd.Run()
+  if exitErr, ok := err.(*exec.ExitError); ok {
+    code = exitErr.ExitCode()
+  } else if err != nil {
+    t.Fatal(err)
+  }
+  return out.String(), errOut.String(), code
+}
+
+func TestRender(t *testing.T) {
+  dir := t.TempDir()
+  write := func(name, content string) string {
+    path := filepath.Join(dir, name)
+    if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+      t.Fatal(err)
+    }
+    return path
+  }
+  data := write("entry.md", "---\ntitle: Hello\n---\nbody")
+
+  tests := []struct {
+    name   string
+    tmpl   string
+    stdout string
+    stderr string
+    code   int
+  }{
+    {"ok", `<h1>{{ .title }}</h1>`, "<h1>Hello</h1>", "", 0},
+    {"missing key", `{{ .nope }}`, "", "preview.html:1:3: missing key .nope\n", 0},
+    {"execution error", `a{{ index .title 10 }}`, "a", "preview.html:1:4: error calling index: index out of range: 10\n", 1},
+    {"parse error", `{{ .title `, "", "preview.html:1: unclosed action\n", 1},
+  }
+
+  for _, test := range tests {
+    tmpl := write("preview.html", test.tmpl)
+    stdout, stderr, code := run(t, tmpl, data)
+    if stdout != test.stdout || stderr != test.stderr || code != test.code {
+      t.Errorf("%s: got %q, %q, exit %d, want %q, %q, exit %d", test.name, stdout, stderr, code, test.stdout, test.stderr, test.code)
+    }
+  }
+}
+
+func TestUsage(t *testing.T) {
+  _, stderr, code := run(t, "only-one-arg")
+  if code != 2 || !strings.Contains(stderr, "usage: tplrender") {
+    t.Errorf("got exit %d and %q, want exit 2 and the usage", code, stderr)
+  }
+}
+
+func TestMissingFile(t *testing.T) {
+  _, stderr, code := run(t, filepath.Join(t.TempDir(), "nope.html"), "data.json")
+  if code != 1 || !strings.HasPrefix(stderr, "tplrender: ") {
+    t.Errorf("got exit %d and %q, want exit 1 and a tplrender error", code, stderr)
+  }
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+  "flag"
+  "fmt"
+  "html/template"
+  "log"
+  "net/http"
+  "os"
+  "path"
+  "path/filepath"
+  "regexp"
+  "sort"
+  "strings"
+  "sync"
+  "time"
+
+  "github.com/erquhart/netlify-cms-template-parser-go/hugo/output"
+  "github.com/erquhart/netlify-cms-template-parser-go/render"
+  "github.com/fsnotify/fsnotify"
+)
+
+const (
+  // reloadPath is the path of the server-sent event stream telling previews
+  // to reload.
+  reloadPath = "/_reload"
+
+  // settleDelay is how long the server waits for changes to stop before
+  // reloading, so that saving several files reloads once.
+  settleDelay = 100 * time.Millisecond
+)
+
+// reloadScript is added to every page served, reloading it whenever a
+// layout or entry changes.
+const reloadScript = `<script>new EventSource("` + reloadPath + `").addEventListener("reload", function () { location.reload(); });</script>`
+
+// serve runs the serve command, which renders each entry under an entries
+// directory through the layout Hugo would choose for it, and reloads the
+// pages in the browser whenever a layout or entry changes.
+func serve(args []string) {
+  flags := flag.NewFlagSet("serve", flag.ExitOnError)
+  flags.Usage = func() {
+    fmt.Fprintln(flags.Output(), "usage: tplrender serve [flags]")
+    flags.PrintDefaults()
+  }
+  var (
+    addr       = flags.String("addr", "localhost:1313", "`address` to listen on")
+    layouts    = flags.String("layouts", "layouts", "Hugo layouts `dir`")
+    entries    = flags.String("entries", "content", "`dir` of sample entries, organized in sections")
+    configFile = flags.String("config", "", "site config `file` read by the site function")
+    format     = flags.String("format", "", "Hugo output `format`, such as HTML, JSON or RSS (default HTML)")
+  )
+  flags.Parse(args)
+  if flags.NArg() != 0 {
+    flags.Usage()
+    os.Exit(2)
+  }
+
+  s := &server{
+    layouts:    *layouts,
+    entries:    *entries,
+    configFile: *configFile,
+    opts:       render.Options{Format: *format},
+    clients:    make(map[chan struct{}]bool),
+  }
+  s.load()
+
+  watcher, err := fsnotify.NewWatcher()
+  if err != nil {
+    fatal(err)
+  }
+  defer watcher.Close()
+  for _, dir := range []string{s.layouts, s.entries} {
+    if err := watchTree(watcher, dir); err != nil {
+      fatal(err)
+    }
+  }
+  if s.configFile != "" {
+    if err := watcher.Add(s.configFile); err != nil {
+      fatal(err)
+    }
+  }
+  go s.watch(watcher)
+
+  http.HandleFunc(reloadPath, s.serveReload)
+  http.HandleFunc("/", s.serveEntry)
+  log.Printf("serving %s on http://%s/", s.entries, *addr)
+  fatal(http.ListenAndServe(*addr, nil))
+}
+
+// server renders entries with the environment built from its layouts and
+// config, rebuilding it when they change.
+type server struct {
+  layouts    string
+  entries    string
+  configFile string
+  opts       render.Options
+
+  mu  sync.RWMutex
+  env *render.Environment
+  err error
+
+  clientsMu sync.Mutex
+  clients   map[chan struct{}]bool
+}
+
+// load rebuilds the environment from the layouts and config, so that removed
+// layouts are dropped too.
+func (s *server) load() {
+  env, err := newEnvironment(s.configFile, s.layouts)
+  if err != nil {
+    log.Print(err)
+  }
+  s.mu.Lock()
+  s.env, s.err = env, err
+  s.mu.Unlock()
+}
+
+// environment returns the current environment, or the error that prevented
+// building it.
+func (s *server) environment() (*render.Environment, error) {
+  s.mu.RLock()
+  defer s.mu.RUnlock()
+  return s.env, s.err
+}
+
+// watch reloads the environment and the previews once a burst of changes
+// has settled. Entries are read again on every request, so changing them
+// only reloads the previews.
+func (s *server) watch(watcher *fsnotify.Watcher) {
+  var (
+    timer   *time.Timer
+    pending = make(chan struct{}, 1)
+  )
+  for {
+    select {
+    case event, ok := <-watcher.Events:
+      if !ok {
+        return
+      }
+      if event.Has(fsnotify.Create) {
+        if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+          if err := watchTree(watcher, event.Name); err != nil {
+            log.Print(err)
+          }
+        }
+      }
+      if timer == nil {
+        timer = time.AfterFunc(settleDelay, func() {
+          select {
+          case pending <- struct{}{}:
+          default:
+          }
+        })
+      } else {
+        timer.Reset(settleDelay)
+      }
+    case <-pending:
+      s.load()
+      s.notify()
+    case err, ok := <-watcher.Errors:
+      if !ok {
+        return
+      }
+      log.Print(err)
+    }
+  }
+}
+
+// watchTree adds dir and every directory under it to watcher.
+func watchTree(watcher *fsnotify.Watcher, dir string) error {
+  return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+    if err != nil || !info.IsDir() {
+      return err
+    }
+    return watcher.Add(path)
+  })
+}
+
+// subscribe returns a channel receiving a value whenever previews should
+// reload.
+func (s *server) subscribe() chan struct{} {
+  ch := make(chan struct{}, 1)
+  s.clientsMu.Lock()
+  s.clients[ch] = true
+  s.clientsMu.Unlock()
+  return ch
+}
+
+func (s *server) unsubscribe(ch chan struct{}) {
+  s.clientsMu.Lock()
+  delete(s.clients, ch)
+  s.clientsMu.Unlock()
+}
+
+// notify tells every subscribed preview to reload.
+func (s *server) notify() {
+  s.clientsMu.Lock()
+  defer s.clientsMu.Unlock()
+  for ch := range s.clients {
+    select {
+    case ch <- struct{}{}:
+    default:
+    }
+  }
+}
+
+// serveReload streams a reload event whenever previews should reload.
+func (s *server) serveReload(w http.ResponseWriter, r *http.Request) {
+  flusher, ok := w.(http.Flusher)
+  if !ok {
+    http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+    return
+  }
+  w.Header().Set("Content-Type", "text/event-stream")
+  w.Header().Set("Cache-Control", "no-cache")
+
+  ch := s.subscribe()
+  defer s.unsubscribe(ch)
+  fmt.Fprint(w, ": connected\n\n")
+  flusher.Flush()
+
+  for {
+    select {
+    case <-ch:
+      fmt.Fprint(w, "event: reload\ndata: {}\n\n")
+      flusher.Flush()
+    case <-r.Context().Done():
+      return
+    }
+  }
+}
+
+// serveEntry renders the entry at the request path, such as
+// "/posts/hello/" for "posts/hello.md" in the entries directory, or lists
+// the entries at "/".
+func (s *server) serveEntry(w http.ResponseWriter, r *http.Request) {
+  names, err := entryNames(s.entries)
+  if err != nil {
+    s.serveErrors(w, http.StatusInternalServerError, err.Error())
+    return
+  }
+
+  if r.URL.Path == "/" {
+    s.serveIndex(w, names)
+    return
+  }
+
+  want := strings.Trim(r.URL.Path, "/")
+  for _, name := range names {
+    if entryURL(name) == "/"+want+"/" {
+      s.renderEntry(w, name)
+      return
+    }
+  }
+  http.NotFound(w, r)
+}
+
+// renderEntry renders the named entry through the layout Hugo would choose
+// for it, as compileEntry does.
+func (s *server) renderEntry(w http.ResponseWriter, name string) {
+  env, err := s.environment()
+  if err != nil {
+    s.serveErrors(w, http.StatusInternalServerError, err.Error())
+    return
+  }
+  data, err := readData(filepath.Join(s.entries, filepath.FromSlash(name)))
+  if err != nil {
+    s.serveErrors(w, http.StatusInternalServerError, err.Error())
+    return
+  }
+
+  result, err := env.RenderEntry(data, entryDescriptor(name, data), s.opts)
+  report(result)
+  if err != nil {
+    messages := make([]string, len(result.Errors))
+    for i, e := range result.Errors {
+      messages[i] = position(e.Name, e.Line, e.Column) + ": " + e.Message
+    }
+    s.serveErrors(w, http.StatusInternalServerError, messages...)
+    return
+  }
+
+  if s.opts.Format != "" && !strings.EqualFold(s.opts.Format, "HTML") {
+    // Only HTML previews can reload themselves.
+    w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+    fmt.Fprint(w, result.HTML)
+    return
+  }
+  w.Header().Set("Content-Type", "text/html; charset=utf-8")
+  fmt.Fprint(w, withReloadScript(result.HTML))
+}
+
+// entryDescriptor describes the entry at name, e.g. "posts/hello.md", the
+// way Hugo would: its section is its top level directory, and its type and
+// layout can be set in its front matter.
+func entryDescriptor(name string, data map[string]interface{}) output.LayoutDescriptor {
+  d := output.LayoutDescriptor{Kind: output.KindPage}
+  if i := strings.Index(name, "/"); i >= 0 {
+    d.Section = name[:i]
+  }
+  d.Type, _ = data["type"].(string)
+  if d.Type == "" {
+    d.Type = d.Section
+  }
+  d.Layout, _ = data["layout"].(string)
+  return d
+}
+
+// entryNames returns the slash separated paths of the files under dir.
+func entryNames(dir string) ([]string, error) {
+  var names []string
+  err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+    if err != nil || info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+      return err
+    }
+    name, err := filepath.Rel(dir, path)
+    if err != nil {
+      return err
+    }
+    names = append(names, filepath.ToSlash(name))
+    return nil
+  })
+  sort.Strings(names)
+  return names, err
+}
+
+// entryURL returns the path an entry is served at.
+func entryURL(name string) string {
+  return "/" + strings.TrimSuffix(name, path.Ext(name)) + "/"
+}
+
+var pageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{ .Title }}</title></head>
+<body>
+<h1>{{ .Title }}</h1>
+{{ with .Errors }}<pre>{{ range . }}{{ . }}
+{{ end }}</pre>{{ end }}
+{{ with .Entries }}<ul>{{ range . }}<li><a href="{{ .URL }}">{{ .Name }}</a></li>{{ end }}</ul>{{ end }}
+</body>
+</html>
+`))
+
+type page struct {
+  Title   string
+  Errors  []string
+  Entries []struct{ Name, URL string }
+}
+
+// serveIndex lists the entries with links to their previews.
+func (s *server) serveIndex(w http.ResponseWriter, names []string) {
+  p := page{Title: s.entries}
+  for _, name := range names {
+    p.Entries = append(p.Entries, struct{ Name, URL string }{name, entryURL(name)})
+  }
+  s.servePage(w, http.StatusOK, p)
+}
+
+// serveErrors shows messages in place of a preview, reloading once they
+// may have been fixed.
+func (s *server) serveErrors(w http.ResponseWriter, status int, messages ...string) {
+  s.servePage(w, status, page{Title: "Render failed", Errors: messages})
+}
+
+func (s *server) servePage(w http.ResponseWriter, status int, p page) {
+  var b strings.Builder
+  if err := pageTemplate.Execute(&b, p); err != nil {
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
+  w.Header().Set("Content-Type", "text/html; charset=utf-8")
+  w.WriteHeader(status)
+  fmt.Fprint(w, withReloadScript(b.String()))
+}
+
+// bodyEndRe matches the closing tag of a body, in any case.
+var bodyEndRe = regexp.MustCompile(`(?i)</body>`)
+
+// withReloadScript adds reloadScript to the end of the body of html.
+func withReloadScript(html string) string {
+  if ends := bodyEndRe.FindAllStringIndex(html, -1); ends != nil {
+    i := ends[len(ends)-1][0]
+    return html[:i] + reloadScript + html[i:]
+  }
+  return html + reloadScript
+}
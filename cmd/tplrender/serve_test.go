@@ -0,0 +1,48 @@
+package main
+
+import (
+  "testing"
+
+  "github.com/erquhart/netlify-cms-template-parser-go/hugo/output"
+)
+
+func TestWithReloadScript(t *testing.T) {
+  tests := []struct {
+    name string
+    html string
+    want string
+  }{
+    {"body", "<body><p>a</p></body></html>", "<body><p>a</p>" + reloadScript + "</body></html>"},
+    {"upper case", "<BODY>a</BODY>", "<BODY>a" + reloadScript + "</BODY>"},
+    {"last body", "<p></body></p></body>", "<p></body></p>" + reloadScript + "</body>"},
+    {"no body", "<p>a</p>", "<p>a</p>" + reloadScript},
+    // Lowering İ changes its length, which must not shift the insertion.
+    {"changing case changes length", "<p>İ</p></body>", "<p>İ</p>" + reloadScript + "</body>"},
+  }
+
+  for _, test := range tests {
+    if got := withReloadScript(test.html); got != test.want {
+      t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+    }
+  }
+}
+
+func TestEntryDescriptor(t *testing.T) {
+  tests := []struct {
+    name string
+    data map[string]interface{}
+    want output.LayoutDescriptor
+  }{
+    {"hello.md", nil, output.LayoutDescriptor{Kind: output.KindPage}},
+    {"posts/hello.md", nil, output.LayoutDescriptor{Kind: output.KindPage, Section: "posts", Type: "posts"}},
+    {"posts/2017/hello.md", nil, output.LayoutDescriptor{Kind: output.KindPage, Section: "posts", Type: "posts"}},
+    {"posts/hello.md", map[string]interface{}{"type": "blog", "layout": "wide"}, output.LayoutDescriptor{Kind: output.KindPage, Section: "posts", Type: "blog", Layout: "wide"}},
+    {"posts/hello.md", map[string]interface{}{"type": 1, "layout": true}, output.LayoutDescriptor{Kind: output.KindPage, Section: "posts", Type: "posts"}},
+  }
+
+  for _, test := range tests {
+    if got := entryDescriptor(test.name, test.data); got != test.want {
+      t.Errorf("%s %v: got %+v, want %+v", test.name, test.data, got, test.want)
+    }
+  }
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+  "github.com/erquhart/netlify-cms-template-parser-go/analysis"
+  "github.com/erquhart/netlify-cms-template-parser-go/render"
+)
+
+// The functions below convert the values returned by the render package to
+// values GopherJS exposes as plain JS objects.
+
+func resultToMap(r render.Result) map[string]interface{} {
+  keys := make([]interface{}, len(r.MissingKeys))
+  for i, key := range r.MissingKeys {
+    keys[i] = missingKeyToMap(key)
+  }
+  scratch := r.Scratch
+  if scratch == nil {
+    scratch = map[string]interface{}{}
+  }
+  m := map[string]interface{}{
+    "html":                 r.HTML,
+    "errors":               errorsToMap(r.Errors),
+    "missingKeys":          keys,
+    "missingKeysTruncated": r.MissingKeysTruncated,
+    "scratch":              scratch,
+  }
+  if r.SourceMap != nil {
+    spans := make([]interface{}, len(r.SourceMap))
+    for i, span := range r.SourceMap {
+      spans[i] = sourceSpanToMap(span)
+    }
+    m["sourceMap"] = spans
+  }
+  if r.Patches != nil {
+    patches := make([]interface{}, len(r.Patches))
+    for i, patch := range r.Patches {
+      patches[i] = patchToMap(patch)
+    }
+    m["patches"] = patches
+  }
+  return m
+}
+
+func errorsToMap(errs []*render.TemplateError) []interface{} {
+  m := make([]interface{}, len(errs))
+  for i, e := range errs {
+    m[i] = map[string]interface{}{
+      "name":    e.Name,
+      "line":    e.Line,
+      "column":  e.Column,
+      "action":  e.Action,
+      "message": e.Message,
+      "limit":   e.Limit,
+      "error":   e.Error(),
+    }
+  }
+  return m
+}
+
+// errorToMap converts err to an array holding a single error, as found in
+// results.
+func errorToMap(err error) []interface{} {
+  return errorsToMap([]*render.TemplateError{render.NewTemplateError(err)})
+}
+
+func missingKeyToMap(k *render.MissingKey) map[string]interface{} {
+  return map[string]interface{}{
+    "key":    k.Key,
+    "name":   k.Name,
+    "line":   k.Line,
+    "column": k.Column,
+  }
+}
+
+func sourceSpanToMap(s *render.SourceSpan) map[string]interface{} {
+  return map[string]interface{}{
+    "start":  s.Start,
+    "end":    s.End,
+    "name":   s.Name,
+    "line":   s.Line,
+    "column": s.Column,
+    "action": s.Action,
+    "path":   s.Path,
+  }
+}
+
+func patchToMap(p *render.Patch) map[string]interface{} {
+  path := make([]interface{}, len(p.Path))
+  for i, index := range p.Path {
+    path[i] = index
+  }
+  m := map[string]interface{}{"op": p.Op, "path": path}
+  switch p.Op {
+  case "insert", "replace":
+    m["html"] = p.HTML
+  case "replaceText":
+    m["text"] = p.Text
+  case "setAttribute":
+    m["name"] = p.Name
+    m["value"] = p.Value
+  case "removeAttribute":
+    m["name"] = p.Name
+  }
+  return m
+}
+
+func reportToMap(r *analysis.Report) map[string]interface{} {
+  fields := make([]interface{}, len(r.Fields))
+  for i, f := range r.Fields {
+    fields[i] = map[string]interface{}{
+      "chain":    f.Chain,
+      "path":     f.Path,
+      "template": f.Template,
+      "line":     f.Line,
+      "column":   f.Column,
+    }
+  }
+
+  functions := make([]interface{}, len(r.Functions))
+  for i, f := range r.Functions {
+    functions[i] = map[string]interface{}{
+      "name":     f.Name,
+      "args":     f.Args,
+      "template": f.Template,
+      "line":     f.Line,
+      "column":   f.Column,
+    }
+  }
+
+  partials := make([]interface{}, len(r.Partials))
+  for i, p := range r.Partials {
+    partials[i] = map[string]interface{}{
+      "name":     p.Name,
+      "context":  p.Context,
+      "found":    p.Found,
+      "template": p.Template,
+      "line":     p.Line,
+      "column":   p.Column,
+    }
+  }
+
+  return map[string]interface{}{
+    "fields":        fields,
+    "functions":     functions,
+    "partials":      partials,
+    "fieldPaths":    r.FieldPaths(),
+    "functionNames": r.FunctionNames(),
+    "partialNames":  r.PartialNames(),
+  }
+}
+
+func problemsToMap(problems []*analysis.Problem) []interface{} {
+  m := make([]interface{}, len(problems))
+  for i, p := range problems {
+    m[i] = map[string]interface{}{
+      "rule":     p.Rule,
+      "message":  p.Message,
+      "template": p.Template,
+      "line":     p.Line,
+      "column":   p.Column,
+    }
+  }
+  return m
+}
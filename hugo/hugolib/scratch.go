@@ -16,41 +16,76 @@
 package hugolib
 
 import (
+  "errors"
+  "fmt"
   "reflect"
   "sort"
+  "strings"
   "sync"
 
+  "github.com/erquhart/netlify-cms-template-parser-go/hugo/tpl/collections"
   "github.com/erquhart/netlify-cms-template-parser-go/hugo/tpl/math"
 )
 
 // Scratch is a writable context used for stateful operations in Page/Node rendering.
+//
+// A scratch returned by Scope reads through to its parent but writes only to
+// its own values, so that partials rendered in parallel or re-entrantly can
+// each be given a scope without clobbering each other's values. Scopes are
+// never created implicitly: as in Hugo, a partial sees the scratch of the
+// context it is given, and its writes are seen by its caller. A template
+// isolates a partial by passing a scope in its context, e.g.
+// {{ partial "menu.html" (dict "Scratch" .Scratch.Scope "Page" .) }}.
 type Scratch struct {
   values map[string]interface{}
+  parent *Scratch
   mu     sync.RWMutex
 }
 
+// deleted marks a key deleted in a scope, hiding the value of its parent.
+type deleted struct{}
+
+// Scope returns a new scratch that reads through to c for the keys it does
+// not hold itself, and holds whatever is written to it. Values inherited
+// from c are copied before they are changed in place, so c is left as it
+// was.
+func (c *Scratch) Scope() *Scratch {
+  return &Scratch{values: make(map[string]interface{}), parent: c}
+}
+
 // Add will, for single values, add (using the + operator) the addend to the existing addend (if found).
 // Supports numeric values and strings.
 //
 // If the first add for a key is an array or slice, then the next value(s) will be appended.
+//
+// If the first add for a key is a map, then the next maps will be merged into it, recursively
+// for nested maps. Other values of the maps added replace those with the same key.
+//
+// The value is read and written under a single lock, so concurrent adds to
+// the same key are not lost.
 func (c *Scratch) Add(key string, newAddend interface{}) (string, error) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
 
   var newVal interface{}
-  c.mu.RLock()
-  existingAddend, found := c.values[key]
-  c.mu.RUnlock()
+  existingAddend, found := c.own(key)
   if found {
     var err error
 
     addendV := reflect.ValueOf(existingAddend)
 
-    if addendV.Kind() == reflect.Slice || addendV.Kind() == reflect.Array {
-      nav := reflect.ValueOf(newAddend)
-      if nav.Kind() == reflect.Slice || nav.Kind() == reflect.Array {
-        newVal = reflect.AppendSlice(addendV, nav).Interface()
-      } else {
-        newVal = reflect.Append(addendV, nav).Interface()
+    if existingMap, ok := toStringMap(existingAddend); ok {
+      newMap, ok := toStringMap(newAddend)
+      if !ok {
+        return "", fmt.Errorf("cannot add a %T to the map %q", newAddend, key)
+      }
+      newVal = mergeMaps(existingMap, newMap)
+    } else if addendV.Kind() == reflect.Slice || addendV.Kind() == reflect.Array {
+      list := asSlice(addendV)
+      for _, v := range elements(newAddend) {
+        list = appendValue(list, v)
       }
+      newVal = list.Interface()
     } else {
       newVal, err = math.DoArithmetic(existingAddend, newAddend, '+')
       if err != nil {
@@ -60,12 +95,161 @@ func (c *Scratch) Add(key string, newAddend interface{}) (string, error) {
   } else {
     newVal = newAddend
   }
-  c.mu.Lock()
   c.values[key] = newVal
-  c.mu.Unlock()
   return "", nil // have to return something to make it work with the Go templates
 }
 
+// AddUnique appends to the slice stored with key each of values, a single
+// value or a slice, that it does not hold yet. Values are compared as the in
+// function compares them: strings by value and numbers of any kind by their
+// numeric value. A missing key starts out as an empty slice.
+func (c *Scratch) AddUnique(key string, values interface{}) (string, error) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  list, err := c.list(key)
+  if err != nil {
+    return "", err
+  }
+  for _, v := range elements(values) {
+    if !collections.In(list.Interface(), v.Interface()) {
+      list = appendValue(list, v)
+    }
+  }
+  c.values[key] = list.Interface()
+  return "", nil
+}
+
+// Intersect removes from the slice stored with key the values not among
+// values, a single value or a slice, comparing them as AddUnique does.
+func (c *Scratch) Intersect(key string, values interface{}) (string, error) {
+  return c.filter(key, values, true)
+}
+
+// Subtract removes from the slice stored with key the values among values,
+// a single value or a slice, comparing them as AddUnique does.
+func (c *Scratch) Subtract(key string, values interface{}) (string, error) {
+  return c.filter(key, values, false)
+}
+
+// filter keeps the values of the slice stored with key that are among
+// values if keep is set, or that are not otherwise.
+func (c *Scratch) filter(key string, values interface{}, keep bool) (string, error) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  list, err := c.list(key)
+  if err != nil {
+    return "", err
+  }
+
+  set := make([]interface{}, 0)
+  for _, v := range elements(values) {
+    set = append(set, v.Interface())
+  }
+  filtered := reflect.MakeSlice(list.Type(), 0, list.Len())
+  for i := 0; i < list.Len(); i++ {
+    v := list.Index(i)
+    if collections.In(set, v.Interface()) == keep {
+      filtered = reflect.Append(filtered, v)
+    }
+  }
+  c.values[key] = filtered.Interface()
+  return "", nil
+}
+
+// list returns the slice stored with key, or an empty one if there is none.
+// c.mu must be held for writing.
+func (c *Scratch) list(key string) (reflect.Value, error) {
+  v, found := c.own(key)
+  if !found || v == nil {
+    return reflect.ValueOf([]interface{}{}), nil
+  }
+  rv := reflect.ValueOf(v)
+  if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+    return reflect.Value{}, fmt.Errorf("scratch value %q is a %T, not a slice", key, v)
+  }
+  return asSlice(rv), nil
+}
+
+// elements returns the elements of v if it is a slice or array, or else v
+// itself. Nil values are left out.
+func elements(v interface{}) []reflect.Value {
+  rv := reflect.ValueOf(v)
+  switch rv.Kind() {
+  case reflect.Invalid:
+    return nil
+  case reflect.Slice, reflect.Array:
+    var values []reflect.Value
+    for i := 0; i < rv.Len(); i++ {
+      if e := reflect.ValueOf(rv.Index(i).Interface()); e.IsValid() {
+        values = append(values, e)
+      }
+    }
+    return values
+  default:
+    return []reflect.Value{rv}
+  }
+}
+
+// asSlice returns a slice holding the elements of the array or slice v.
+func asSlice(v reflect.Value) reflect.Value {
+  if v.Kind() == reflect.Slice {
+    return v
+  }
+  s := reflect.MakeSlice(reflect.SliceOf(v.Type().Elem()), v.Len(), v.Len())
+  reflect.Copy(s, v)
+  return s
+}
+
+// appendValue appends v to list, turning list into a []interface{} if v
+// cannot be stored in its elements.
+func appendValue(list, v reflect.Value) reflect.Value {
+  if v.Type().AssignableTo(list.Type().Elem()) {
+    return reflect.Append(list, v)
+  }
+  s := make([]interface{}, list.Len(), list.Len()+1)
+  for i := range s {
+    s[i] = list.Index(i).Interface()
+  }
+  return reflect.ValueOf(append(s, v.Interface()))
+}
+
+// toStringMap returns v as a map[string]interface{} if it is a map with
+// string keys.
+func toStringMap(v interface{}) (map[string]interface{}, bool) {
+  if m, ok := v.(map[string]interface{}); ok {
+    return m, true
+  }
+  rv := reflect.ValueOf(v)
+  if rv.Kind() != reflect.Map || rv.Type().Key().Kind() != reflect.String {
+    return nil, false
+  }
+  m := make(map[string]interface{}, rv.Len())
+  iter := rv.MapRange()
+  for iter.Next() {
+    m[iter.Key().String()] = iter.Value().Interface()
+  }
+  return m, true
+}
+
+// mergeMaps returns a copy of dst with the values of src merged into it.
+// Maps found in both are merged in turn; other values of src replace those
+// of dst.
+func mergeMaps(dst, src map[string]interface{}) map[string]interface{} {
+  merged := make(map[string]interface{}, len(dst)+len(src))
+  for key, value := range dst {
+    merged[key] = value
+  }
+  for key, value := range src {
+    if dstMap, ok := toStringMap(merged[key]); ok {
+      if srcMap, ok := toStringMap(value); ok {
+        value = mergeMaps(dstMap, srcMap)
+      }
+    }
+    merged[key] = value
+  }
+  return merged
+}
+
 // Set stores a value with the given key in the Node context.
 // This value can later be retrieved with Get.
 func (c *Scratch) Set(key string, value interface{}) string {
@@ -75,40 +259,212 @@ func (c *Scratch) Set(key string, value interface{}) string {
   return ""
 }
 
+// Delete deletes the given key. In a scope, the value of the parent is
+// hidden rather than deleted.
+func (c *Scratch) Delete(key string) string {
+  c.mu.Lock()
+  if c.parent != nil {
+    c.values[key] = deleted{}
+  } else {
+    delete(c.values, key)
+  }
+  c.mu.Unlock()
+  return ""
+}
+
 // Get returns a value previously set by Add or Set
 func (c *Scratch) Get(key string) interface{} {
   c.mu.RLock()
-  val := c.values[key]
+  val, _ := c.lookup(key)
   c.mu.RUnlock()
 
   return val
 }
 
+// lookup returns the value stored with key, or else the one its parent
+// holds. c.mu must be held.
+func (c *Scratch) lookup(key string) (interface{}, bool) {
+  if v, found := c.values[key]; found {
+    if _, ok := v.(deleted); ok {
+      return nil, false
+    }
+    return v, true
+  }
+  if c.parent == nil {
+    return nil, false
+  }
+  c.parent.mu.RLock()
+  defer c.parent.mu.RUnlock()
+  return c.parent.lookup(key)
+}
+
+// own returns the value stored with key as lookup does, first storing a copy
+// of it if it is inherited from the parent, so that it can be changed in
+// place. c.mu must be held for writing.
+func (c *Scratch) own(key string) (interface{}, bool) {
+  v, found := c.lookup(key)
+  if !found {
+    return nil, false
+  }
+  if _, local := c.values[key]; !local {
+    v = copyValue(v)
+    c.values[key] = v
+  }
+  return v, true
+}
+
+// copyValue returns a copy of v that shares no maps or slices with it that
+// the scratch changes in place.
+func copyValue(v interface{}) interface{} {
+  if m, ok := v.(map[string]interface{}); ok {
+    copied := make(map[string]interface{}, len(m))
+    for key, value := range m {
+      copied[key] = copyValue(value)
+    }
+    return copied
+  }
+  rv := reflect.ValueOf(v)
+  if rv.Kind() != reflect.Slice || rv.IsNil() {
+    return v
+  }
+  copied := reflect.MakeSlice(rv.Type(), rv.Len(), rv.Len())
+  reflect.Copy(copied, rv)
+  return copied.Interface()
+}
+
 // SetInMap stores a value to a map with the given key in the Node context.
 // This map can later be retrieved with GetSortedMapValues.
-func (c *Scratch) SetInMap(key string, mapKey string, value interface{}) string {
+//
+// The arguments after key are the keys of the map to set, followed by the
+// value. More than one key sets the value in a nested map, creating the maps
+// along the way: SetInMap "authors" "jane" "posts" 3 sets
+// (index (.Get "authors") "jane" "posts") to 3.
+func (c *Scratch) SetInMap(key string, mapKeysAndValue ...interface{}) (string, error) {
+  if len(mapKeysAndValue) < 2 {
+    return "", errors.New("SetInMap needs a map key and a value")
+  }
+  path, err := mapPath(mapKeysAndValue[:len(mapKeysAndValue)-1])
+  if err != nil {
+    return "", err
+  }
+  value := mapKeysAndValue[len(mapKeysAndValue)-1]
+
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  m, err := c.mapAt(key, path[:len(path)-1], true)
+  if err != nil {
+    return "", err
+  }
+  m[path[len(path)-1]] = value
+  return "", nil
+}
+
+// DeleteInMap deletes a key from a map previously filled with SetInMap. As
+// with SetInMap, more than one key deletes from a nested map. Deleting from
+// a map that does not exist does nothing.
+func (c *Scratch) DeleteInMap(key string, mapKeys ...interface{}) (string, error) {
+  if len(mapKeys) == 0 {
+    return "", errors.New("DeleteInMap needs a map key")
+  }
+  path, err := mapPath(mapKeys)
+  if err != nil {
+    return "", err
+  }
+
   c.mu.Lock()
-  _, found := c.values[key]
+  defer c.mu.Unlock()
+  m, err := c.mapAt(key, path[:len(path)-1], false)
+  if m != nil {
+    delete(m, path[len(path)-1])
+  }
+  return "", err
+}
+
+// mapAt returns the map found by following path from the map stored with
+// key, or nil if there is none. If create is set, missing maps are created.
+// It fails if a value along the way is not a map. c.mu must be held for
+// writing.
+func (c *Scratch) mapAt(key string, path []string, create bool) (map[string]interface{}, error) {
+  v, found := c.own(key)
   if !found {
-    c.values[key] = make(map[string]interface{})
+    if !create {
+      return nil, nil
+    }
+    v = make(map[string]interface{})
+    c.values[key] = v
+  }
+  m, ok := v.(map[string]interface{})
+  if !ok {
+    return nil, fmt.Errorf("scratch value %q is a %T, not a map", key, v)
   }
 
-  c.values[key].(map[string]interface{})[mapKey] = value
-  c.mu.Unlock()
-  return ""
+  for i, mapKey := range path {
+    v, found := m[mapKey]
+    if !found {
+      if !create {
+        return nil, nil
+      }
+      v = make(map[string]interface{})
+      m[mapKey] = v
+    }
+    next, ok := v.(map[string]interface{})
+    if !ok {
+      return nil, fmt.Errorf("scratch value %q at %q is a %T, not a map", key, strings.Join(path[:i+1], "."), v)
+    }
+    m = next
+  }
+  return m, nil
+}
+
+// mapPath converts the map keys passed to SetInMap or DeleteInMap to
+// strings.
+func mapPath(keys []interface{}) ([]string, error) {
+  path := make([]string, len(keys))
+  for i, k := range keys {
+    s, ok := k.(string)
+    if !ok {
+      return nil, fmt.Errorf("map key %v is a %T, not a string", k, k)
+    }
+    path[i] = s
+  }
+  return path, nil
+}
+
+// Values returns a copy of the values stored in the scratch, keyed by their
+// keys. Unlike the backing map it is safe to use while templates write to
+// the scratch. The values of a scope include those it inherits.
+func (c *Scratch) Values() map[string]interface{} {
+  values := make(map[string]interface{})
+  if c.parent != nil {
+    values = c.parent.Values()
+  }
+
+  c.mu.RLock()
+  defer c.mu.RUnlock()
+  for key, value := range c.values {
+    if _, ok := value.(deleted); ok {
+      delete(values, key)
+    } else {
+      values[key] = value
+    }
+  }
+  return values
 }
 
 // GetSortedMapValues returns a sorted map previously filled with SetInMap
-func (c *Scratch) GetSortedMapValues(key string) interface{} {
+func (c *Scratch) GetSortedMapValues(key string) (interface{}, error) {
   c.mu.RLock()
+  defer c.mu.RUnlock()
 
-  if c.values[key] == nil {
-    c.mu.RUnlock()
-    return nil
+  v, _ := c.lookup(key)
+  if v == nil {
+    return nil, nil
   }
 
-  unsortedMap := c.values[key].(map[string]interface{})
-  c.mu.RUnlock()
+  unsortedMap, ok := v.(map[string]interface{})
+  if !ok {
+    return nil, fmt.Errorf("scratch value %q is a %T, not a map", key, v)
+  }
   var keys []string
   for mapKey := range unsortedMap {
     keys = append(keys, mapKey)
@@ -121,7 +477,7 @@ func (c *Scratch) GetSortedMapValues(key string) interface{} {
     sortedArray[i] = unsortedMap[mapKey]
   }
 
-  return sortedArray
+  return sortedArray, nil
 }
 
 func NewScratch() *Scratch {
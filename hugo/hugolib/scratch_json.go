@@ -0,0 +1,289 @@
+package hugolib
+
+import (
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "reflect"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// The JSON form of a Scratch is an object of its values. Numbers of a kind
+// other than float64, such as the int64 and uint64 DoArithmetic produces,
+// are written as an object with a single key naming the kind, e.g.
+// {"$int64": 3}, so that they come back as the same kind. Integers too large
+// to be represented exactly in JS are written as strings. Times, nested
+// scratches and maps that could be mistaken for a tagged value are tagged
+// the same way.
+
+const (
+  tagPrefix  = "$"
+  tagTime    = "$time"
+  tagScratch = "$scratch"
+  tagMap     = "$map"
+
+  // maxSafeInteger is the largest integer JS represents exactly.
+  maxSafeInteger = 1<<53 - 1
+)
+
+// MarshalJSON returns the values of the scratch as a JSON object.
+func (c *Scratch) MarshalJSON() ([]byte, error) {
+  values, err := encodeValues(c.Values())
+  if err != nil {
+    return nil, err
+  }
+  return json.Marshal(values)
+}
+
+// UnmarshalJSON replaces the values of the scratch with those of the JSON
+// object in data, as written by MarshalJSON. In a scope, the keys of the
+// parent missing from data are hidden, so that the values of the scope are
+// those of data while the parent is left as it is.
+func (c *Scratch) UnmarshalJSON(data []byte) error {
+  dec := json.NewDecoder(bytes.NewReader(data))
+  dec.UseNumber()
+  var raw map[string]interface{}
+  if err := dec.Decode(&raw); err != nil {
+    return err
+  }
+
+  values := make(map[string]interface{}, len(raw))
+  for key, v := range raw {
+    value, err := decodeValue(v)
+    if err != nil {
+      return fmt.Errorf("scratch value %q: %v", key, err)
+    }
+    values[key] = value
+  }
+
+  if c.parent != nil {
+    for key := range c.parent.Values() {
+      if _, found := values[key]; !found {
+        values[key] = deleted{}
+      }
+    }
+  }
+
+  c.mu.Lock()
+  c.values = values
+  c.mu.Unlock()
+  return nil
+}
+
+func encodeValues(values map[string]interface{}) (map[string]interface{}, error) {
+  encoded := make(map[string]interface{}, len(values))
+  for key, value := range values {
+    v, err := encodeValue(value)
+    if err != nil {
+      return nil, fmt.Errorf("scratch value %q: %v", key, err)
+    }
+    encoded[key] = v
+  }
+  return encoded, nil
+}
+
+// encodeValue converts v to a value encoding/json writes in the JSON form
+// of a Scratch.
+func encodeValue(v interface{}) (interface{}, error) {
+  switch v := v.(type) {
+  case nil, bool, string, float64:
+    return v, nil
+  case time.Time:
+    return map[string]interface{}{tagTime: v.Format(time.RFC3339Nano)}, nil
+  case *Scratch:
+    values, err := encodeValues(v.Values())
+    if err != nil {
+      return nil, err
+    }
+    return map[string]interface{}{tagScratch: values}, nil
+  case json.Marshaler:
+    return v, nil
+  }
+
+  rv := reflect.ValueOf(v)
+  switch rv.Kind() {
+  case reflect.Bool:
+    return rv.Bool(), nil
+  case reflect.String:
+    return rv.String(), nil
+  case reflect.Float64:
+    return rv.Float(), nil
+  case reflect.Float32:
+    return tagged(rv.Kind(), rv.Float()), nil
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    n := rv.Int()
+    if n > maxSafeInteger || n < -maxSafeInteger {
+      return tagged(rv.Kind(), strconv.FormatInt(n, 10)), nil
+    }
+    return tagged(rv.Kind(), n), nil
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+    n := rv.Uint()
+    if n > maxSafeInteger {
+      return tagged(rv.Kind(), strconv.FormatUint(n, 10)), nil
+    }
+    return tagged(rv.Kind(), n), nil
+  case reflect.Slice, reflect.Array:
+    s := make([]interface{}, rv.Len())
+    for i := range s {
+      e, err := encodeValue(rv.Index(i).Interface())
+      if err != nil {
+        return nil, err
+      }
+      s[i] = e
+    }
+    return s, nil
+  case reflect.Map:
+    m, ok := toStringMap(v)
+    if !ok {
+      return nil, fmt.Errorf("cannot encode a %T", v)
+    }
+    encoded, err := encodeValues(m)
+    if err != nil {
+      return nil, err
+    }
+    if isTagged(encoded) {
+      return map[string]interface{}{tagMap: encoded}, nil
+    }
+    return encoded, nil
+  case reflect.Ptr, reflect.Interface:
+    if rv.IsNil() {
+      return nil, nil
+    }
+    return encodeValue(rv.Elem().Interface())
+  }
+  return nil, fmt.Errorf("cannot encode a %T", v)
+}
+
+func tagged(kind reflect.Kind, value interface{}) map[string]interface{} {
+  return map[string]interface{}{tagPrefix + kind.String(): value}
+}
+
+// isTagged reports whether m has the form of a tagged value.
+func isTagged(m map[string]interface{}) bool {
+  if len(m) != 1 {
+    return false
+  }
+  for key := range m {
+    return strings.HasPrefix(key, tagPrefix)
+  }
+  return false
+}
+
+// numberKinds are the kinds of numbers that can be tagged, by tag.
+var numberKinds = map[string]reflect.Type{}
+
+func init() {
+  for _, v := range []interface{}{
+    int(0), int8(0), int16(0), int32(0), int64(0),
+    uint(0), uint8(0), uint16(0), uint32(0), uint64(0), uintptr(0),
+    float32(0),
+  } {
+    t := reflect.TypeOf(v)
+    numberKinds[tagPrefix+t.Kind().String()] = t
+  }
+}
+
+// decodeValue converts a value decoded by encoding/json, with UseNumber
+// set, back to the value it was encoded from.
+func decodeValue(v interface{}) (interface{}, error) {
+  switch v := v.(type) {
+  case json.Number:
+    return v.Float64()
+  case []interface{}:
+    s := make([]interface{}, len(v))
+    for i, e := range v {
+      d, err := decodeValue(e)
+      if err != nil {
+        return nil, err
+      }
+      s[i] = d
+    }
+    return s, nil
+  case map[string]interface{}:
+    if isTagged(v) {
+      for tag, value := range v {
+        return decodeTagged(tag, value)
+      }
+    }
+    return decodeMap(v)
+  }
+  return v, nil
+}
+
+func decodeMap(m map[string]interface{}) (map[string]interface{}, error) {
+  decoded := make(map[string]interface{}, len(m))
+  for key, value := range m {
+    d, err := decodeValue(value)
+    if err != nil {
+      return nil, err
+    }
+    decoded[key] = d
+  }
+  return decoded, nil
+}
+
+func decodeTagged(tag string, value interface{}) (interface{}, error) {
+  switch tag {
+  case tagTime:
+    s, ok := value.(string)
+    if !ok {
+      return nil, fmt.Errorf("%s value is a %T, not a string", tag, value)
+    }
+    return time.Parse(time.RFC3339Nano, s)
+  case tagScratch:
+    m, ok := value.(map[string]interface{})
+    if !ok {
+      return nil, fmt.Errorf("%s value is a %T, not an object", tag, value)
+    }
+    values, err := decodeMap(m)
+    if err != nil {
+      return nil, err
+    }
+    return &Scratch{values: values}, nil
+  case tagMap:
+    m, ok := value.(map[string]interface{})
+    if !ok {
+      return nil, fmt.Errorf("%s value is a %T, not an object", tag, value)
+    }
+    return decodeMap(m)
+  }
+
+  t, ok := numberKinds[tag]
+  if !ok {
+    return nil, fmt.Errorf("unknown tag %q", tag)
+  }
+  var s string
+  switch value := value.(type) {
+  case json.Number:
+    s = value.String()
+  case string:
+    s = value
+  default:
+    return nil, fmt.Errorf("%s value is a %T, not a number", tag, value)
+  }
+
+  n := reflect.New(t).Elem()
+  switch t.Kind() {
+  case reflect.Float32:
+    f, err := strconv.ParseFloat(s, 32)
+    if err != nil {
+      return nil, err
+    }
+    n.SetFloat(f)
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+    u, err := strconv.ParseUint(s, 10, t.Bits())
+    if err != nil {
+      return nil, err
+    }
+    n.SetUint(u)
+  default:
+    i, err := strconv.ParseInt(s, 10, t.Bits())
+    if err != nil {
+      return nil, err
+    }
+    n.SetInt(i)
+  }
+  return n.Interface(), nil
+}
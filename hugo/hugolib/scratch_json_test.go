@@ -0,0 +1,92 @@
+package hugolib
+
+import (
+  "encoding/json"
+  "reflect"
+  "testing"
+  "time"
+)
+
+func TestScratchJSONRoundTrip(t *testing.T) {
+  values := map[string]interface{}{
+    "int":       int(-3),
+    "int8":      int8(-8),
+    "int16":     int16(-16),
+    "int32":     int32(-32),
+    "int64":     int64(-64),
+    "bigInt64":  int64(1<<62 + 1),
+    "uint":      uint(3),
+    "uint8":     uint8(8),
+    "uint16":    uint16(16),
+    "uint32":    uint32(32),
+    "uint64":    uint64(64),
+    "bigUint64": uint64(1<<64 - 1),
+    "uintptr":   uintptr(7),
+    "float32":   float32(1.5),
+    "float64":   2.5,
+    "string":    "s",
+    "bool":      true,
+    "nil":       nil,
+    "time":      time.Date(2017, 3, 4, 5, 6, 7, 8, time.UTC),
+    "list":      []interface{}{int64(1), "a", 1.5},
+    "map":       map[string]interface{}{"n": uint8(1), "s": "a"},
+    "taggedMap": map[string]interface{}{"$int": "not a number"},
+    "scratch":   &Scratch{values: map[string]interface{}{"n": int32(2)}},
+  }
+
+  c := &Scratch{values: values}
+  data, err := json.Marshal(c)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  restored := &Scratch{values: map[string]interface{}{"stale": 1.0}}
+  if err := json.Unmarshal(data, restored); err != nil {
+    t.Fatal(err)
+  }
+
+  got := restored.Values()
+  if len(got) != len(values) {
+    t.Errorf("got %d values, want %d", len(got), len(values))
+  }
+  for key, want := range values {
+    v := got[key]
+    if s, ok := want.(*Scratch); ok {
+      want = s.Values()
+      restoredScratch, ok := v.(*Scratch)
+      if !ok {
+        t.Errorf("%s: got a %T, want a *Scratch", key, v)
+        continue
+      }
+      v = restoredScratch.Values()
+    }
+    if !reflect.DeepEqual(v, want) {
+      t.Errorf("%s: got %#v, want %#v", key, v, want)
+    }
+  }
+}
+
+func TestScratchJSONScope(t *testing.T) {
+  parent := &Scratch{values: map[string]interface{}{"a": 1.0, "b": 2.0}}
+  scope := parent.Scope()
+  scope.Set("c", 3.0)
+
+  if err := json.Unmarshal([]byte(`{"b": 20, "d": 4}`), scope); err != nil {
+    t.Fatal(err)
+  }
+
+  if got, want := scope.Values(), map[string]interface{}{"b": 20.0, "d": 4.0}; !reflect.DeepEqual(got, want) {
+    t.Errorf("scope: got %v, want %v", got, want)
+  }
+  if got, want := parent.Values(), map[string]interface{}{"a": 1.0, "b": 2.0}; !reflect.DeepEqual(got, want) {
+    t.Errorf("parent: got %v, want %v", got, want)
+  }
+
+  data, err := json.Marshal(scope)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if got, want := string(data), `{"b":20,"d":4}`; got != want {
+    t.Errorf("snapshot: got %s, want %s", got, want)
+  }
+}
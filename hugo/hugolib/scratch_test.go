@@ -0,0 +1,411 @@
+package hugolib
+
+import (
+  "reflect"
+  "strings"
+  "sync"
+  "testing"
+)
+
+func TestScratchErrors(t *testing.T) {
+  tests := []struct {
+    name string
+    op   func(c *Scratch) error
+    err  string
+  }{
+    {"add number to string", func(c *Scratch) error {
+      _, err := c.Add("s", 1)
+      return err
+    }, "apply the operator"},
+    {"add string to number", func(c *Scratch) error {
+      _, err := c.Add("n", "a")
+      return err
+    }, "apply the operator"},
+    {"set in string", func(c *Scratch) error {
+      _, err := c.SetInMap("s", "a", 1)
+      return err
+    }, `scratch value "s" is a string, not a map`},
+    {"set in nested string", func(c *Scratch) error {
+      _, err := c.SetInMap("m", "a", "b", 1)
+      return err
+    }, `scratch value "m" at "a" is a string, not a map`},
+    {"set with a number key", func(c *Scratch) error {
+      _, err := c.SetInMap("m", 1, 1)
+      return err
+    }, "map key 1 is a int, not a string"},
+    {"set without a value", func(c *Scratch) error {
+      _, err := c.SetInMap("m", "a")
+      return err
+    }, "needs a map key and a value"},
+    {"delete in string", func(c *Scratch) error {
+      _, err := c.DeleteInMap("s", "a")
+      return err
+    }, `scratch value "s" is a string, not a map`},
+    {"delete without a key", func(c *Scratch) error {
+      _, err := c.DeleteInMap("m")
+      return err
+    }, "needs a map key"},
+    {"sorted values of a string", func(c *Scratch) error {
+      _, err := c.GetSortedMapValues("s")
+      return err
+    }, `scratch value "s" is a string, not a map`},
+    {"sorted values of a list", func(c *Scratch) error {
+      _, err := c.GetSortedMapValues("l")
+      return err
+    }, `scratch value "l" is a []interface {}, not a map`},
+  }
+
+  for _, test := range tests {
+    c := NewScratch()
+    c.Set("s", "text")
+    c.Set("n", 1)
+    c.Set("l", []interface{}{1})
+    c.Set("m", map[string]interface{}{"a": "text"})
+    want := c.Values()
+
+    err := test.op(c)
+    if err == nil || !strings.Contains(err.Error(), test.err) {
+      t.Errorf("%s: got error %v, want one containing %q", test.name, err, test.err)
+    }
+    if got := c.Values(); !reflect.DeepEqual(got, want) {
+      t.Errorf("%s: values changed to %v", test.name, got)
+    }
+  }
+}
+
+func TestScratchDeleteMissing(t *testing.T) {
+  c := NewScratch()
+  c.SetInMap("m", "a", 1)
+
+  c.Delete("nope")
+  for _, keys := range [][]interface{}{{"nope"}, {"nope", "deeper"}} {
+    if _, err := c.DeleteInMap("m", keys...); err != nil {
+      t.Errorf("delete %v in m: %v", keys, err)
+    }
+  }
+  if _, err := c.DeleteInMap("nope", "a"); err != nil {
+    t.Errorf("delete in missing map: %v", err)
+  }
+
+  want := map[string]interface{}{"m": map[string]interface{}{"a": 1}}
+  if got := c.Values(); !reflect.DeepEqual(got, want) {
+    t.Errorf("got %v, want %v", got, want)
+  }
+  if v, err := c.GetSortedMapValues("nope"); v != nil || err != nil {
+    t.Errorf("sorted values of a missing key: got %v, %v, want nil", v, err)
+  }
+}
+
+func TestScratchAdd(t *testing.T) {
+  tests := []struct {
+    name   string
+    start  interface{}
+    addend interface{}
+    want   interface{}
+    err    bool
+  }{
+    {"new key", nil, 1, 1, false},
+    {"numbers", 1, 2, int64(3), false},
+    {"strings", "a", "b", "ab", false},
+    {"slice", []string{"a"}, "b", []string{"a", "b"}, false},
+    {"slice of other type", []string{"a"}, 1, []interface{}{"a", 1}, false},
+    {"slice to slice", []interface{}{1}, []string{"a", "b"}, []interface{}{1, "a", "b"}, false},
+    {"array", [1]int{1}, 2, []int{1, 2}, false},
+    {"nil to slice", []interface{}{1}, nil, []interface{}{1}, false},
+    {"map", map[string]interface{}{"a": 1}, map[string]interface{}{"b": 2}, map[string]interface{}{"a": 1, "b": 2}, false},
+    {"map replaces values", map[string]interface{}{"a": 1}, map[string]interface{}{"a": 2}, map[string]interface{}{"a": 2}, false},
+    {
+      "nested maps",
+      map[string]interface{}{"a": map[string]interface{}{"x": 1, "y": map[string]interface{}{"p": 1}}},
+      map[string]interface{}{"a": map[string]interface{}{"y": map[string]interface{}{"q": 2}, "z": 3}},
+      map[string]interface{}{"a": map[string]interface{}{"x": 1, "y": map[string]interface{}{"p": 1, "q": 2}, "z": 3}},
+      false,
+    },
+    {"map replaced by value", map[string]interface{}{"a": map[string]interface{}{"x": 1}}, map[string]interface{}{"a": 1}, map[string]interface{}{"a": 1}, false},
+    {"map of other type", map[string]int{"a": 1}, map[string]string{"b": "x"}, map[string]interface{}{"a": 1, "b": "x"}, false},
+    {"empty map", map[string]interface{}{}, map[string]interface{}{}, map[string]interface{}{}, false},
+    {"nil map", map[string]interface{}(nil), map[string]interface{}{"a": 1}, map[string]interface{}{"a": 1}, false},
+    {"value to map", map[string]interface{}{"a": 1}, 1, nil, true},
+    {"map to number", 1, map[string]interface{}{"a": 1}, nil, true},
+  }
+
+  for _, test := range tests {
+    c := NewScratch()
+    if test.start != nil {
+      c.Set("k", test.start)
+    }
+    _, err := c.Add("k", test.addend)
+    if (err != nil) != test.err {
+      t.Errorf("%s: got error %v, want error %v", test.name, err, test.err)
+      continue
+    }
+    if !test.err && !reflect.DeepEqual(c.Get("k"), test.want) {
+      t.Errorf("%s: got %#v, want %#v", test.name, c.Get("k"), test.want)
+    }
+  }
+}
+
+func TestScratchSetOperations(t *testing.T) {
+  type op func(c *Scratch, key string, values interface{}) (string, error)
+  addUnique := (*Scratch).AddUnique
+  intersect := (*Scratch).Intersect
+  subtract := (*Scratch).Subtract
+
+  tests := []struct {
+    name   string
+    op     op
+    start  interface{}
+    values interface{}
+    want   interface{}
+  }{
+    {"add unique to missing key", addUnique, nil, []string{"a", "a", "b"}, []interface{}{"a", "b"}},
+    {"add unique value", addUnique, []string{"a"}, "b", []string{"a", "b"}},
+    {"add unique seen value", addUnique, []string{"a"}, "a", []string{"a"}},
+    {"add unique numbers of any kind", addUnique, []interface{}{1}, []interface{}{1.0, int64(1), uint8(2)}, []interface{}{1, uint8(2)}},
+    {"add unique other type", addUnique, []string{"a"}, 1, []interface{}{"a", 1}},
+    {"add unique to array", addUnique, [2]string{"a", "b"}, "c", []string{"a", "b", "c"}},
+    {"add unique nothing", addUnique, []string{"a"}, []string{}, []string{"a"}},
+    {"add unique nil", addUnique, []string{"a"}, nil, []string{"a"}},
+    {"add unique to nil", addUnique, []string(nil), "a", []string{"a"}},
+    {"intersect", intersect, []string{"a", "b", "c"}, []string{"c", "a", "d"}, []string{"a", "c"}},
+    {"intersect numbers of any kind", intersect, []int{1, 2, 3}, []interface{}{3.0, int64(1)}, []int{1, 3}},
+    {"intersect value", intersect, []string{"a", "b"}, "b", []string{"b"}},
+    {"intersect nothing", intersect, []string{"a"}, []string{}, []string{}},
+    {"intersect nil", intersect, []string{"a"}, nil, []string{}},
+    {"intersect missing key", intersect, nil, []string{"a"}, []interface{}{}},
+    {"subtract", subtract, []string{"a", "b", "c"}, []string{"c", "a", "d"}, []string{"b"}},
+    {"subtract numbers of any kind", subtract, []interface{}{1, "1", 2.5}, []interface{}{1.0, float32(2.5)}, []interface{}{"1"}},
+    {"subtract value", subtract, []string{"a", "b"}, "b", []string{"a"}},
+    {"subtract nothing", subtract, []string{"a"}, []string{}, []string{"a"}},
+    {"subtract nil", subtract, []string{"a"}, nil, []string{"a"}},
+    {"subtract missing key", subtract, nil, []string{"a"}, []interface{}{}},
+  }
+
+  for _, test := range tests {
+    c := NewScratch()
+    if test.start != nil {
+      c.Set("k", test.start)
+    }
+    if _, err := test.op(c, "k", test.values); err != nil {
+      t.Errorf("%s: %v", test.name, err)
+      continue
+    }
+    if got := c.Get("k"); !reflect.DeepEqual(got, test.want) {
+      t.Errorf("%s: got %#v, want %#v", test.name, got, test.want)
+    }
+  }
+
+  c := NewScratch()
+  c.Set("k", "text")
+  for name, op := range map[string]op{"AddUnique": addUnique, "Intersect": intersect, "Subtract": subtract} {
+    if _, err := op(c, "k", "a"); err == nil || !strings.Contains(err.Error(), "not a slice") {
+      t.Errorf("%s on a string: got error %v, want one containing %q", name, err, "not a slice")
+    }
+  }
+}
+
+func TestScopeWrites(t *testing.T) {
+  parent := NewScratch()
+  parent.Set("n", 1)
+  parent.Set("list", []interface{}{"a"})
+  parent.SetInMap("authors", "jo", "posts", 1)
+
+  scope := parent.Scope()
+  scope.Set("n", 2)
+  scope.Set("new", true)
+  if _, err := scope.Add("list", "b"); err != nil {
+    t.Fatal(err)
+  }
+  if _, err := scope.SetInMap("authors", "jo", "posts", 2); err != nil {
+    t.Fatal(err)
+  }
+  if _, err := scope.AddUnique("tags", "x"); err != nil {
+    t.Fatal(err)
+  }
+
+  want := map[string]interface{}{
+    "n":       1,
+    "list":    []interface{}{"a"},
+    "authors": map[string]interface{}{"jo": map[string]interface{}{"posts": 1}},
+  }
+  if got := parent.Values(); !reflect.DeepEqual(got, want) {
+    t.Errorf("parent: got %v, want %v", got, want)
+  }
+
+  want = map[string]interface{}{
+    "n":       2,
+    "new":     true,
+    "list":    []interface{}{"a", "b"},
+    "authors": map[string]interface{}{"jo": map[string]interface{}{"posts": 2}},
+    "tags":    []interface{}{"x"},
+  }
+  if got := scope.Values(); !reflect.DeepEqual(got, want) {
+    t.Errorf("scope: got %v, want %v", got, want)
+  }
+}
+
+func TestScopeReadsThrough(t *testing.T) {
+  parent := NewScratch()
+  scope := parent.Scope()
+
+  parent.Set("a", 1)
+  if got := scope.Get("a"); got != 1 {
+    t.Errorf("before override: got %v, want 1", got)
+  }
+
+  scope.Set("a", 2)
+  parent.Set("a", 3)
+  if got := scope.Get("a"); got != 2 {
+    t.Errorf("after override: got %v, want 2", got)
+  }
+
+  nested := scope.Scope()
+  parent.Set("b", 4)
+  if got := nested.Get("b"); got != 4 {
+    t.Errorf("nested scope: got %v, want 4", got)
+  }
+
+  if _, err := scope.Add("c", 1); err != nil {
+    t.Fatal(err)
+  }
+  parent.Set("c", 10)
+  if got := scope.Get("c"); got != 1 {
+    t.Errorf("added in scope: got %v, want 1", got)
+  }
+}
+
+func TestScopeDelete(t *testing.T) {
+  parent := NewScratch()
+  parent.Set("a", 1)
+  parent.Set("b", 2)
+
+  scope := parent.Scope()
+  scope.Delete("a")
+  if got := scope.Get("a"); got != nil {
+    t.Errorf("deleted key: got %v, want nil", got)
+  }
+  if got, want := scope.Values(), map[string]interface{}{"b": 2}; !reflect.DeepEqual(got, want) {
+    t.Errorf("scope: got %v, want %v", got, want)
+  }
+  if got := parent.Get("a"); got != 1 {
+    t.Errorf("parent: got %v, want 1", got)
+  }
+
+  // Adding to a deleted key starts over rather than adding to the parent's
+  // value.
+  if _, err := scope.Add("a", 5); err != nil {
+    t.Fatal(err)
+  }
+  if got := scope.Get("a"); got != 5 {
+    t.Errorf("added after delete: got %v, want 5", got)
+  }
+}
+
+func TestScopeDeleteMissing(t *testing.T) {
+  parent := NewScratch()
+  parent.SetInMap("m", "a", 1)
+  parent.Set("s", "text")
+  scope := parent.Scope()
+
+  // Deleting a key neither the scope nor its parent holds hides nothing.
+  scope.Delete("nope")
+  if _, err := scope.DeleteInMap("nope", "a"); err != nil {
+    t.Errorf("delete in missing map: %v", err)
+  }
+  if _, err := scope.DeleteInMap("m", "nope"); err != nil {
+    t.Errorf("delete missing key of inherited map: %v", err)
+  }
+  if _, err := scope.DeleteInMap("s", "a"); err == nil {
+    t.Error("delete in inherited string: no error")
+  }
+  want := map[string]interface{}{"m": map[string]interface{}{"a": 1}, "s": "text"}
+  if got := scope.Values(); !reflect.DeepEqual(got, want) {
+    t.Errorf("scope: got %v, want %v", got, want)
+  }
+
+  // Deleting from an inherited map changes the scope's copy only.
+  if _, err := scope.DeleteInMap("m", "a"); err != nil {
+    t.Fatal(err)
+  }
+  if got, want := scope.Get("m"), map[string]interface{}{}; !reflect.DeepEqual(got, want) {
+    t.Errorf("scope map: got %v, want %v", got, want)
+  }
+  if got, want := parent.Get("m"), map[string]interface{}{"a": 1}; !reflect.DeepEqual(got, want) {
+    t.Errorf("parent map: got %v, want %v", got, want)
+  }
+
+  // A deleted key stays hidden from the scope and its own scopes, even once
+  // the parent sets it again, and reads as missing everywhere.
+  scope.Delete("s")
+  scope.Delete("s")
+  parent.Set("s", "again")
+  nested := scope.Scope()
+  for name, c := range map[string]*Scratch{"scope": scope, "nested": nested} {
+    if got := c.Get("s"); got != nil {
+      t.Errorf("%s: got %v, want nil", name, got)
+    }
+    if _, found := c.Values()["s"]; found {
+      t.Errorf("%s: deleted key in values", name)
+    }
+    if v, err := c.GetSortedMapValues("s"); v != nil || err != nil {
+      t.Errorf("%s: sorted values of deleted key: got %v, %v, want nil", name, v, err)
+    }
+    if _, err := c.DeleteInMap("s", "a"); err != nil {
+      t.Errorf("%s: delete in deleted key: %v", name, err)
+    }
+  }
+  if got := parent.Get("s"); got != "again" {
+    t.Errorf("parent: got %v, want again", got)
+  }
+}
+
+func TestScopeConcurrentAdd(t *testing.T) {
+  parent := NewScratch()
+  parent.Set("n", int64(0))
+  parent.Set("list", []interface{}{})
+
+  const scopes, adds = 8, 100
+  children := make([]*Scratch, scopes)
+  for i := range children {
+    children[i] = parent.Scope()
+  }
+
+  var wg sync.WaitGroup
+  add := func(c *Scratch) {
+    defer wg.Done()
+    for i := 0; i < adds; i++ {
+      if _, err := c.Add("n", int64(1)); err != nil {
+        t.Error(err)
+      }
+      if _, err := c.Add("list", i); err != nil {
+        t.Error(err)
+      }
+      c.Get("n")
+      c.Values()
+    }
+  }
+  wg.Add(scopes + 1)
+  go add(parent)
+  for _, c := range children {
+    go add(c)
+  }
+  wg.Wait()
+
+  if got := parent.Get("n"); got != int64(adds) {
+    t.Errorf("parent: got n = %v, want %d", got, adds)
+  }
+  if got := len(parent.Get("list").([]interface{})); got != adds {
+    t.Errorf("parent: got %d list items, want %d", got, adds)
+  }
+  for i, c := range children {
+    // Each scope adds to the parent's value as it was when the scope first
+    // wrote to the key.
+    n := c.Get("n").(int64)
+    if n < adds || n > 2*adds {
+      t.Errorf("scope %d: got n = %d, want between %d and %d", i, n, adds, 2*adds)
+    }
+    if got := len(c.Get("list").([]interface{})); got < adds || got > 2*adds {
+      t.Errorf("scope %d: got %d list items, want between %d and %d", i, got, adds, 2*adds)
+    }
+  }
+}
@@ -0,0 +1,165 @@
+// Copyright 2017 The Hugo Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// With modifications by the Netlify CMS Authors.
+
+package output
+
+import (
+  "path"
+  "strings"
+)
+
+// Page kinds, as used in LayoutDescriptor.Kind.
+const (
+  KindPage         = "page"
+  KindHome         = "home"
+  KindSection      = "section"
+  KindTaxonomy     = "taxonomy"
+  KindTaxonomyTerm = "taxonomyTerm"
+)
+
+// themePrefix is prepended to the names of layouts that come from a theme.
+const themePrefix = "theme/"
+
+// LayoutDescriptor describes how a layout should be chosen. This is
+// typically built from a Page.
+type LayoutDescriptor struct {
+  // Type is the content type, which defaults to Section.
+  Type string
+  // Section is the content section, or the singular taxonomy name for
+  // taxonomy kinds.
+  Section string
+  // Kind is one of the Kind constants. Unknown kinds are treated as pages.
+  Kind string
+  // Layout is the layout set in front matter, if any.
+  Layout string
+}
+
+// Layouts returns the layout names to try for d in the output format f, most
+// specific first. Names are relative to the layouts directory; theme layouts
+// follow the project ones with a "theme/" prefix.
+func Layouts(d LayoutDescriptor, f Format) []string {
+  return withTheme(resolvePageTemplate(d, f))
+}
+
+// BaseLayouts returns the base template names to try for a template found
+// under the given layout name, e.g. "posts/single.html".
+func BaseLayouts(layout string) []string {
+  layout = strings.TrimPrefix(layout, themePrefix)
+  dir, file := path.Split(layout)
+  ext := path.Ext(file)
+  name := strings.TrimSuffix(file, ext)
+
+  var layouts []string
+  if dir != "" {
+    layouts = append(layouts, dir+name+"-baseof"+ext, dir+"baseof"+ext)
+  }
+  layouts = append(layouts,
+    "_default/"+name+"-baseof"+ext,
+    "_default/baseof"+ext,
+  )
+
+  return withTheme(layouts)
+}
+
+// Resolve returns the first of the candidate names for which exists reports
+// true.
+func Resolve(candidates []string, exists func(name string) bool) (string, bool) {
+  for _, name := range candidates {
+    if exists(name) {
+      return name, true
+    }
+  }
+  return "", false
+}
+
+type layoutBuilder struct {
+  f       Format
+  layouts []string
+}
+
+// add appends each name in each of dirs, e.g. "single.json.json" and
+// "single.json" for the JSON format. HTML layouts only use the suffix.
+func (b *layoutBuilder) add(dirs []string, names ...string) {
+  for _, dir := range dirs {
+    for _, name := range names {
+      if name == "" {
+        continue
+      }
+      if !strings.EqualFold(b.f.Name, HTMLFormat.Name) {
+        b.layouts = append(b.layouts, path.Join(dir, name+"."+strings.ToLower(b.f.Name)+"."+b.f.Suffix))
+      }
+      b.layouts = append(b.layouts, path.Join(dir, name+"."+b.f.Suffix))
+    }
+  }
+}
+
+func resolvePageTemplate(d LayoutDescriptor, f Format) []string {
+  b := &layoutBuilder{f: f}
+
+  // RSS feeds also look for rss.xml next to the list layouts.
+  var rss string
+  if f.Name == RSSFormat.Name {
+    rss = "rss"
+  }
+
+  typ := d.Type
+  if typ == "" {
+    typ = d.Section
+  }
+
+  switch d.Kind {
+  case KindHome:
+    b.add([]string{"", "_default"}, d.Layout, "index", "home", rss, "list")
+  case KindSection:
+    var dirs []string
+    if typ != "" {
+      dirs = append(dirs, typ)
+    }
+    b.add(dirs, d.Layout, d.Section, "section", rss, "list")
+    if d.Section != "" {
+      b.add([]string{"section"}, d.Section)
+    }
+    b.add([]string{"_default"}, d.Layout, "section", rss, "list")
+  case KindTaxonomy:
+    if d.Section != "" {
+      b.add([]string{"taxonomy"}, d.Section)
+    }
+    b.add([]string{"_default"}, d.Layout, "taxonomy", rss, "list")
+  case KindTaxonomyTerm:
+    if d.Section != "" {
+      b.add([]string{"taxonomy"}, d.Section+".terms")
+    }
+    b.add([]string{"_default"}, d.Layout, "terms", "list")
+  default:
+    var dirs []string
+    if typ != "" {
+      dirs = append(dirs, typ)
+    }
+    b.add(append(dirs, "_default"), d.Layout, "single")
+  }
+
+  return b.layouts
+}
+
+// withTheme appends the theme variant of each layout, so that project
+// layouts take precedence over all theme layouts.
+func withTheme(layouts []string) []string {
+  all := make([]string, 0, 2*len(layouts))
+  all = append(all, layouts...)
+  for _, layout := range layouts {
+    all = append(all, themePrefix+layout)
+  }
+  return all
+}
@@ -0,0 +1,177 @@
+package output
+
+import (
+  "reflect"
+  "testing"
+)
+
+// The examples follow Hugo's lookup order documentation, without the
+// "layouts/" directory. Each list is followed by its theme variants.
+func TestLayouts(t *testing.T) {
+  tests := []struct {
+    name string
+    d    LayoutDescriptor
+    f    Format
+    want []string
+  }{
+    {
+      "single page in posts",
+      LayoutDescriptor{Kind: KindPage, Section: "posts"},
+      HTMLFormat,
+      []string{"posts/single.html", "_default/single.html"},
+    },
+    {
+      "single page with a layout",
+      LayoutDescriptor{Kind: KindPage, Section: "posts", Layout: "demolayout"},
+      HTMLFormat,
+      []string{"posts/demolayout.html", "posts/single.html", "_default/demolayout.html", "_default/single.html"},
+    },
+    {
+      "single page with a type",
+      LayoutDescriptor{Kind: KindPage, Section: "posts", Type: "demotype"},
+      HTMLFormat,
+      []string{"demotype/single.html", "_default/single.html"},
+    },
+    {
+      "AMP single page",
+      LayoutDescriptor{Kind: KindPage, Section: "posts"},
+      AMPFormat,
+      []string{"posts/single.amp.html", "posts/single.html", "_default/single.amp.html", "_default/single.html"},
+    },
+    {
+      "unknown kind",
+      LayoutDescriptor{Kind: "other"},
+      HTMLFormat,
+      []string{"_default/single.html"},
+    },
+    {
+      "home page",
+      LayoutDescriptor{Kind: KindHome},
+      HTMLFormat,
+      []string{"index.html", "home.html", "list.html", "_default/index.html", "_default/home.html", "_default/list.html"},
+    },
+    {
+      "home page with a layout",
+      LayoutDescriptor{Kind: KindHome, Layout: "demolayout"},
+      HTMLFormat,
+      []string{"demolayout.html", "index.html", "home.html", "list.html", "_default/demolayout.html", "_default/index.html", "_default/home.html", "_default/list.html"},
+    },
+    {
+      "RSS home",
+      LayoutDescriptor{Kind: KindHome},
+      RSSFormat,
+      []string{
+        "index.rss.xml", "index.xml", "home.rss.xml", "home.xml", "rss.rss.xml", "rss.xml", "list.rss.xml", "list.xml",
+        "_default/index.rss.xml", "_default/index.xml", "_default/home.rss.xml", "_default/home.xml",
+        "_default/rss.rss.xml", "_default/rss.xml", "_default/list.rss.xml", "_default/list.xml",
+      },
+    },
+    {
+      "JSON home",
+      LayoutDescriptor{Kind: KindHome},
+      JSONFormat,
+      []string{
+        "index.json.json", "index.json", "home.json.json", "home.json", "list.json.json", "list.json",
+        "_default/index.json.json", "_default/index.json", "_default/home.json.json", "_default/home.json",
+        "_default/list.json.json", "_default/list.json",
+      },
+    },
+    {
+      "section list",
+      LayoutDescriptor{Kind: KindSection, Section: "posts"},
+      HTMLFormat,
+      []string{"posts/posts.html", "posts/section.html", "posts/list.html", "section/posts.html", "_default/section.html", "_default/list.html"},
+    },
+    {
+      "section list with a type",
+      LayoutDescriptor{Kind: KindSection, Section: "posts", Type: "blog"},
+      HTMLFormat,
+      []string{"blog/posts.html", "blog/section.html", "blog/list.html", "section/posts.html", "_default/section.html", "_default/list.html"},
+    },
+    {
+      "section list with a layout",
+      LayoutDescriptor{Kind: KindSection, Section: "posts", Layout: "demolayout"},
+      HTMLFormat,
+      []string{"posts/demolayout.html", "posts/posts.html", "posts/section.html", "posts/list.html", "section/posts.html", "_default/demolayout.html", "_default/section.html", "_default/list.html"},
+    },
+    {
+      "taxonomy list",
+      LayoutDescriptor{Kind: KindTaxonomy, Section: "category"},
+      HTMLFormat,
+      []string{"taxonomy/category.html", "_default/taxonomy.html", "_default/list.html"},
+    },
+    {
+      "RSS taxonomy list",
+      LayoutDescriptor{Kind: KindTaxonomy, Section: "category"},
+      RSSFormat,
+      []string{"taxonomy/category.rss.xml", "taxonomy/category.xml", "_default/taxonomy.rss.xml", "_default/taxonomy.xml", "_default/rss.rss.xml", "_default/rss.xml", "_default/list.rss.xml", "_default/list.xml"},
+    },
+    {
+      "taxonomy terms",
+      LayoutDescriptor{Kind: KindTaxonomyTerm, Section: "category"},
+      HTMLFormat,
+      []string{"taxonomy/category.terms.html", "_default/terms.html", "_default/list.html"},
+    },
+  }
+
+  for _, test := range tests {
+    got := Layouts(test.d, test.f)
+    want := append(append([]string{}, test.want...), prefixed(test.want)...)
+    if !reflect.DeepEqual(got, want) {
+      t.Errorf("%s:\ngot  %q\nwant %q", test.name, got, want)
+    }
+  }
+}
+
+func TestBaseLayouts(t *testing.T) {
+  tests := []struct {
+    layout string
+    want   []string
+  }{
+    {"posts/single.html", []string{"posts/single-baseof.html", "posts/baseof.html", "_default/single-baseof.html", "_default/baseof.html"}},
+    {"theme/posts/single.html", []string{"posts/single-baseof.html", "posts/baseof.html", "_default/single-baseof.html", "_default/baseof.html"}},
+    {"index.html", []string{"_default/index-baseof.html", "_default/baseof.html"}},
+    {"index.json", []string{"_default/index-baseof.json", "_default/baseof.json"}},
+  }
+
+  for _, test := range tests {
+    got := BaseLayouts(test.layout)
+    want := append(append([]string{}, test.want...), prefixed(test.want)...)
+    if !reflect.DeepEqual(got, want) {
+      t.Errorf("%s:\ngot  %q\nwant %q", test.layout, got, want)
+    }
+  }
+}
+
+func TestResolve(t *testing.T) {
+  d := LayoutDescriptor{Kind: KindPage, Section: "posts"}
+  tests := []struct {
+    name   string
+    exists []string
+    want   string
+  }{
+    {"section over default", []string{"_default/single.html", "posts/single.html"}, "posts/single.html"},
+    {"project over theme", []string{"theme/posts/single.html", "_default/single.html"}, "_default/single.html"},
+    {"theme", []string{"theme/_default/single.html"}, "theme/_default/single.html"},
+    {"none", []string{"posts/list.html"}, ""},
+  }
+
+  for _, test := range tests {
+    exists := make(map[string]bool)
+    for _, name := range test.exists {
+      exists[name] = true
+    }
+    got, ok := Resolve(Layouts(d, HTMLFormat), func(name string) bool { return exists[name] })
+    if got != test.want || ok != (test.want != "") {
+      t.Errorf("%s: got %q, %v, want %q", test.name, got, ok, test.want)
+    }
+  }
+}
+
+func prefixed(layouts []string) []string {
+  var theme []string
+  for _, layout := range layouts {
+    theme = append(theme, themePrefix+layout)
+  }
+  return theme
+}
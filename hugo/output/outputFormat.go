@@ -0,0 +1,125 @@
+// Copyright 2017 The Hugo Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// With modifications by the Netlify CMS Authors.
+
+package output
+
+import (
+  "strings"
+)
+
+var (
+  AMPFormat = Format{
+    Name:   "AMP",
+    Suffix: "html",
+  }
+
+  CalendarFormat = Format{
+    Name:        "Calendar",
+    Suffix:      "ics",
+    IsPlainText: true,
+  }
+
+  CSSFormat = Format{
+    Name:        "CSS",
+    Suffix:      "css",
+    IsPlainText: true,
+  }
+
+  CSVFormat = Format{
+    Name:        "CSV",
+    Suffix:      "csv",
+    IsPlainText: true,
+  }
+
+  HTMLFormat = Format{
+    Name:   "HTML",
+    Suffix: "html",
+  }
+
+  JSONFormat = Format{
+    Name:        "JSON",
+    Suffix:      "json",
+    IsPlainText: true,
+  }
+
+  // RSSFormat is rendered as plain text so that the XML is not escaped as
+  // if it were HTML.
+  RSSFormat = Format{
+    Name:        "RSS",
+    Suffix:      "xml",
+    IsPlainText: true,
+  }
+
+  RobotsTxtFormat = Format{
+    Name:        "ROBOTS",
+    Suffix:      "txt",
+    IsPlainText: true,
+  }
+
+  SitemapFormat = Format{
+    Name:        "Sitemap",
+    Suffix:      "xml",
+    IsPlainText: true,
+  }
+
+  TextFormat = Format{
+    Name:        "Text",
+    Suffix:      "txt",
+    IsPlainText: true,
+  }
+)
+
+// DefaultFormats are the output formats known by name.
+var DefaultFormats = Formats{
+  AMPFormat,
+  CalendarFormat,
+  CSSFormat,
+  CSVFormat,
+  HTMLFormat,
+  JSONFormat,
+  RSSFormat,
+  RobotsTxtFormat,
+  SitemapFormat,
+  TextFormat,
+}
+
+// Format represents an output representation, usually to a file on disk.
+type Format struct {
+  // The Name is used as an identifier. Internal output formats (i.e. HTML and RSS)
+  // can be overridden by providing a new definition for those types.
+  Name string
+
+  // The suffix of the layouts and the files rendered with this format.
+  Suffix string
+
+  // Enable to use text/template instead of html/template, which escapes
+  // output for HTML.
+  IsPlainText bool
+}
+
+// Formats is a slice of Format.
+type Formats []Format
+
+// GetByName gets a format by its identifier name, ignoring case.
+func (formats Formats) GetByName(name string) (f Format, found bool) {
+  for _, ff := range formats {
+    if strings.EqualFold(name, ff.Name) {
+      f = ff
+      found = true
+      return
+    }
+  }
+  return
+}
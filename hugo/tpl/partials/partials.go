@@ -0,0 +1,132 @@
+// Copyright 2017 The Hugo Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// With modifications by the Netlify CMS Authors.
+
+package partials
+
+import (
+  "bytes"
+  "fmt"
+  "html/template"
+  "io"
+  "strings"
+  "sync"
+  texttemplate "text/template"
+)
+
+// Executer is a template that can be executed against a context.
+type Executer interface {
+  Execute(wr io.Writer, data interface{}) error
+}
+
+// Lookup returns the template with the given name, or nil if there is none.
+type Lookup func(name string) Executer
+
+// Namespace provides template functions for the "partials" namespace.
+type Namespace struct {
+  lookup         Lookup
+  cachedPartials map[string]interface{}
+  mu             sync.RWMutex
+}
+
+// New returns a new instance of the partials-namespaced template functions.
+func New(lookup Lookup) *Namespace {
+  return &Namespace{
+    lookup:         lookup,
+    cachedPartials: make(map[string]interface{}),
+  }
+}
+
+// Include executes the named partial and returns either a string,
+// when the partial is a text/template, or template.HTML when html/template.
+func (ns *Namespace) Include(name string, contextList ...interface{}) (interface{}, error) {
+  if strings.HasPrefix(name, "partials/") {
+    name = name[8:]
+  }
+  var context interface{}
+
+  if len(contextList) == 0 {
+    context = nil
+  } else {
+    context = contextList[0]
+  }
+
+  for _, n := range []string{"partials/" + name, "theme/partials/" + name} {
+    templ := ns.lookup(n)
+    if templ == nil {
+      // For legacy reasons.
+      templ = ns.lookup(n + ".html")
+    }
+    if templ != nil {
+      var b bytes.Buffer
+      if err := templ.Execute(&b, context); err != nil {
+        return "", err
+      }
+
+      if isPlainText(templ) {
+        return b.String(), nil
+      }
+
+      return template.HTML(b.String()), nil
+    }
+  }
+
+  return "", fmt.Errorf("partial %q not found", name)
+}
+
+// isPlainText reports whether templ is a text/template, either itself or
+// wrapped in an Executer with an IsPlainText method.
+func isPlainText(templ Executer) bool {
+  if t, ok := templ.(interface{ IsPlainText() bool }); ok {
+    return t.IsPlainText()
+  }
+  _, ok := templ.(*texttemplate.Template)
+  return ok
+}
+
+// IncludeCached executes and caches partial templates. Optional variant
+// arguments can be passed so that a given partial can have multiple uses.
+// The cached result is only the first result.
+func (ns *Namespace) IncludeCached(name string, context interface{}, variants ...interface{}) (interface{}, error) {
+  key := name
+  for _, variant := range variants {
+    key += "\x00" + fmt.Sprint(variant)
+  }
+  return ns.getOrCreate(key, name, context)
+}
+
+func (ns *Namespace) getOrCreate(key, name string, context interface{}) (interface{}, error) {
+  ns.mu.RLock()
+  p, ok := ns.cachedPartials[key]
+  ns.mu.RUnlock()
+
+  if ok {
+    return p, nil
+  }
+
+  p, err := ns.Include(name, context)
+  if err != nil {
+    return nil, err
+  }
+
+  ns.mu.Lock()
+  if cached, ok := ns.cachedPartials[key]; ok {
+    p = cached
+  } else {
+    ns.cachedPartials[key] = p
+  }
+  ns.mu.Unlock()
+
+  return p, nil
+}
@@ -1,49 +1,301 @@
+//go:build !wasm
+
 package main
 
 import (
-  "bytes"
-  "html/template"
+  "context"
 
-  "github.com/erquhart/netlify-cms-template-parser-go/hugo/helpers"
+  "github.com/erquhart/netlify-cms-template-parser-go/hugo/config"
   "github.com/erquhart/netlify-cms-template-parser-go/hugo/hugolib"
-  "github.com/erquhart/netlify-cms-template-parser-go/hugo/tpl/collections"
-  "github.com/erquhart/netlify-cms-template-parser-go/hugo/tpl/encoding"
-  "github.com/erquhart/netlify-cms-template-parser-go/hugo/tpl/safe"
+  "github.com/erquhart/netlify-cms-template-parser-go/render"
   "github.com/gopherjs/gopherjs/js"
-  "gopkg.in/russross/blackfriday.v2"
 )
 
 func main() {
+  api := exports(render.DefaultEnvironment())
+  api["createEnvironment"] = createEnvironment
+
   // For exporting to global/window
-  js.Global.Set("goTemplateParser", map[string]interface{}{
-    "compile": compile,
-    "scratch": hugolib.NewScratch(),
+  js.Global.Set("goTemplateParser", api)
+  js.Module.Get("exports").Set("goTemplateParser", api)
+}
+
+// jsEnvironment exposes the functions of a render.Environment to JS.
+type jsEnvironment struct {
+  env *render.Environment
+}
+
+// exports returns the API of env as exported to JS.
+func exports(env *render.Environment) map[string]interface{} {
+  e := &jsEnvironment{env: env}
+  return map[string]interface{}{
+    "analyze": e.analyze,
+    "compile": e.compile,
+    "compileEntry": e.compileEntry,
+    "compileStream": e.compileStream,
+    "config": configObject(env.Config()),
+    "lint": e.lint,
+    "prepare": e.prepare,
+    "registerFunction": e.registerFunction,
+    "registerLayouts": e.registerLayouts,
+    "registerPartial": e.registerPartial,
+    "removeFunction": e.removeFunction,
+    "removeLayout": env.RemoveLayout,
+    "removePartial": e.removePartial,
+    "resolveLayout": e.resolveLayout,
+    "scratch": scratchObject(env.Scratch()),
+  }
+}
+
+// createEnvironment returns a new environment with the same API as
+// goTemplateParser but partials, layouts, functions, config and scratch of
+// its own, so that several sites can be previewed side by side. options is
+// an optional object of the form { config }, config being the values of
+// the site's Hugo config, available to templates through the site function
+// and to JS through the environment's config object.
+func createEnvironment(options *js.Object) map[string]interface{} {
+  return exports(newEnvironment(goValue(options)))
+}
+
+// compile renders tmpl against data and returns an object holding the
+// rendered html, an array of errors, each with the template name, line,
+// column, failing action and message, the keys missing from data, with
+// missingKeysTruncated set if that list may be incomplete, and the values
+// left in the scratch. options is optional; see optionsFromValue.
+func (e *jsEnvironment) compile(data *js.Object, tmpl string, options *js.Object) map[string]interface{} {
+  result, _ := e.env.Render(data.Interface(), tmpl, optionsFromValue(goValue(options)))
+  return resultToMap(result)
+}
+
+// compileStream renders tmpl against data like compile, but passes the output
+// to onChunk in chunks as it is produced instead of returning it. If onChunk
+// returns a promise, rendering waits for it to settle before going on, and
+// stops if it is rejected. It returns an object whose cancel method stops
+// the render and whose done property is a promise of the result, as
+// returned by compile without its html. options also takes chunkSize, the
+// number of bytes buffered before a chunk is delivered.
+func (e *jsEnvironment) compileStream(data *js.Object, tmpl string, onChunk *js.Object, options *js.Object) map[string]interface{} {
+  return streamJS(e.env.Prepare(tmpl, optionsFromValue(goValue(options))), data, onChunk, options)
+}
+
+// streamJS renders p against data in a goroutine, delivering the output to
+// onChunk.
+func streamJS(p *render.Prepared, data *js.Object, onChunk *js.Object, options *js.Object) map[string]interface{} {
+  ctx, cancel := context.WithCancel(context.Background())
+  var resolve *js.Object
+  done := js.Global.Get("Promise").New(func(res, rej *js.Object) {
+    resolve = res
+  })
+
+  v, size := data.Interface(), chunkSizeFromValue(goValue(options))
+  go func() {
+    defer cancel()
+    resolve.Invoke(renderStream(ctx, p, v, size, func(chunk string) error {
+      return deliverChunk(ctx, onChunk, chunk)
+    }))
+  }()
+
+  return map[string]interface{}{
+    "done":   done,
+    "cancel": cancel,
+  }
+}
+
+// deliverChunk calls onChunk with chunk and waits for the promise it
+// returns, if any, to settle, or for ctx to be done.
+func deliverChunk(ctx context.Context, onChunk *js.Object, chunk string) (err error) {
+  defer func() {
+    if r := recover(); r != nil {
+      jsErr, ok := r.(*js.Error)
+      if !ok {
+        panic(r)
+      }
+      err = jsErr
+    }
+  }()
+
+  settled := make(chan error, 1)
+  js.Global.Get("Promise").Call("resolve", onChunk.Invoke(chunk)).Call("then",
+    func(*js.Object) { settled <- nil },
+    func(reason *js.Object) { settled <- &js.Error{Object: reason} },
+  )
+
+  select {
+  case err := <-settled:
+    return err
+  case <-ctx.Done():
+    return ctx.Err()
+  }
+}
+
+// prepare parses tmpl once and returns a handle whose render method executes
+// it against new data without parsing it again.
+func (e *jsEnvironment) prepare(tmpl string, options *js.Object) map[string]interface{} {
+  return handle(e.env.Prepare(tmpl, optionsFromValue(goValue(options))))
+}
+
+// compileEntry renders data through the registered layout Hugo would choose
+// for the entry described by descriptor, an object of the form
+// { kind, type, section, layout }.
+func (e *jsEnvironment) compileEntry(data *js.Object, descriptor *js.Object, options *js.Object) map[string]interface{} {
+  result, _ := e.env.RenderEntry(data.Interface(), descriptorFromValue(goValue(descriptor)), optionsFromValue(goValue(options)))
+  return resultToMap(result)
+}
+
+// resolveLayout returns the names of the layout and base template Hugo
+// would choose for descriptor in the output format given by options, along
+// with an error message if there is no matching layout.
+func (e *jsEnvironment) resolveLayout(descriptor *js.Object, options *js.Object) map[string]interface{} {
+  return resolveLayoutToMap(e.env, descriptorFromValue(goValue(descriptor)), optionsFromValue(goValue(options)).Format)
+}
+
+// handle wraps p in an object whose render method executes it against new
+// data. Its patch method does the same but also returns, as patches, the
+// operations turning the DOM of the previous output of patch into the new
+// one; each is an object { op, path, html, text, name, value } applied in
+// order. reset makes the next patch replace the whole output. stream(data,
+// onChunk, options) renders as compileStream does. release frees what the
+// handle holds; it must not be used afterwards.
+func handle(p *render.Prepared) map[string]interface{} {
+  patcher := render.NewPatcher(p)
+  return map[string]interface{}{
+    "render": func(data *js.Object) map[string]interface{} {
+      result, _ := p.Render(data.Interface())
+      return resultToMap(result)
+    },
+    "patch": func(data *js.Object) map[string]interface{} {
+      result, _ := patcher.Render(data.Interface())
+      return resultToMap(result)
+    },
+    "reset": patcher.Reset,
+    "stream": func(data *js.Object, onChunk *js.Object, options *js.Object) map[string]interface{} {
+      return streamJS(p, data, onChunk, options)
+    },
+    // Functions are garbage collected under GopherJS, so only the previous
+    // output of patch is held.
+    "release": patcher.Reset,
+  }
+}
+
+// analyze returns the fields, functions and partials tmpl refers to. Each
+// field has its chain as written and its path resolved against the entry
+// data, with list elements written as "*", e.g. "authors.*.name". Parse
+// errors are returned in an errors array.
+func (e *jsEnvironment) analyze(tmpl string, options *js.Object) map[string]interface{} {
+  return analyzeToMap(e.env, tmpl, optionsFromValue(goValue(options)))
+}
+
+// lint checks tmpl against schema, a Netlify CMS collection or its array of
+// fields given as an object or JSON string, and the functions available to
+// templates. It returns an object with an array of problems, each with the
+// rule broken, a message and the template, line and column, and an array of
+// errors if the template or schema could not be parsed. Without a schema,
+// fields are not checked.
+func (e *jsEnvironment) lint(tmpl string, schema *js.Object, options *js.Object) map[string]interface{} {
+  return lintToMap(e.env, tmpl, goValue(schema), optionsFromValue(goValue(options)))
+}
+
+// registerPartial makes source available to templates as the named partial,
+// e.g. registerPartial("cards/post.html", src) for {{ partial "cards/post.html" . }}.
+func (e *jsEnvironment) registerPartial(name, source string) {
+  e.env.RegisterPartial(name, source)
+}
+
+// removePartial unregisters the named partial.
+func (e *jsEnvironment) removePartial(name string) {
+  e.env.RemovePartial(name)
+}
+
+// registerLayouts adds every file of a Hugo layouts tree, given as an object
+// mapping paths such as "layouts/_default/single.html" or
+// "themes/hyde/layouts/partials/head.html" to their source.
+func (e *jsEnvironment) registerLayouts(files map[string]string) {
+  for name, source := range files {
+    e.env.RegisterLayout(name, source)
+  }
+}
+
+// registerFunction makes the JS function fn available to templates under
+// name. Template values are passed to fn converted to their JS equivalents,
+// its return value is converted back to a Go value and an exception thrown
+// by fn becomes a template execution error. fn must return synchronously.
+//
+// It throws an Error if the name cannot be registered.
+func (e *jsEnvironment) registerFunction(name string, fn *js.Object) {
+  if err := e.env.RegisterFunction(name, jsFunc(fn)); err != nil {
+    throw(err)
+  }
+}
+
+// throw raises err in JS as an Error. Panicking with any other value than a
+// *js.Error surfaces in JS as a Go panic rather than an exception that can
+// be told apart by its message.
+func throw(err error) {
+  panic(&js.Error{Object: js.Global.Get("Error").New(err.Error())})
+}
+
+// removeFunction unregisters a function added with registerFunction.
+func (e *jsEnvironment) removeFunction(name string) {
+  e.env.RemoveFunction(name)
+}
+
+// configObject exposes the methods of c to JS under their Go names, e.g.
+// config.GetString("params.author"), converting the values they return.
+func configObject(c config.Provider) *js.Object {
+  return js.MakeWrapper(c)
+}
+
+// scratchObject exposes the methods of s to JS, along with snapshot, which
+// returns its values as a plain object, and restore(obj), which replaces
+// them with those of a snapshot. Numbers other than float64 appear in
+// snapshots as objects naming their kind, e.g. { $int64: 3 }, so that they
+// are restored as the same kind. Scope returns a child scope exposed the same
+// way.
+func scratchObject(s *hugolib.Scratch) *js.Object {
+  o := js.MakeWrapper(s)
+  o.Set("Scope", func() *js.Object {
+    return scratchObject(s.Scope())
+  })
+  o.Set("snapshot", func() *js.Object {
+    data, err := s.MarshalJSON()
+    if err != nil {
+      throw(err)
+    }
+    return js.Global.Get("JSON").Call("parse", string(data))
   })
-  js.Module.Get("exports").Set("goTemplateParser", map[string]interface{}{
-    "compile": compile,
-    "scratch": hugolib.NewScratch(),
+  o.Set("restore", func(snapshot *js.Object) {
+    data := js.Global.Get("JSON").Call("stringify", snapshot).String()
+    if err := s.UnmarshalJSON([]byte(data)); err != nil {
+      throw(err)
+    }
   })
+  return o
+}
+
+// jsFunc wraps fn for use in a FuncMap.
+func jsFunc(fn *js.Object) func(args ...interface{}) (interface{}, error) {
+  return func(args ...interface{}) (result interface{}, err error) {
+    defer func() {
+      if r := recover(); r != nil {
+        jsErr, ok := r.(*js.Error)
+        if !ok {
+          panic(r)
+        }
+        err = jsErr
+      }
+    }()
+    return fn.Invoke(args...).Interface(), nil
+  }
+}
+
+// goValue converts o to a Go value, as the shared bindings expect.
+func goValue(o *js.Object) interface{} {
+  if isUndefined(o) {
+    return nil
+  }
+  return o.Interface()
 }
 
-func renderMarkdown(tmpl string) template.HTML {
-  input := []byte(tmpl)
-  output := blackfriday.Run(input)
-  return template.HTML(output)
-}
-
-func compile(data *js.Object, tmpl string) string {
-  var dataMap = data.Interface()
-  var buf bytes.Buffer
-  var t, _ = template.New("").Funcs(template.FuncMap{
-    "dict": collections.Dictionary,
-    "first": collections.First,
-    "jsonify": encoding.Jsonify,
-    "markdownify": renderMarkdown,
-    "safeJS": safe.JS,
-    "slice": collections.Slice,
-    "urlize": helpers.URLize,
-    "where": collections.Where,
-  }).Parse(tmpl)
-  t.Execute(&buf, dataMap)
-  return buf.String()
+func isUndefined(o *js.Object) bool {
+  return o == nil || o == js.Undefined
 }
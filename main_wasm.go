@@ -0,0 +1,591 @@
+package main
+
+import (
+  "context"
+  "fmt"
+  "reflect"
+  "syscall/js"
+  "time"
+
+  "github.com/erquhart/netlify-cms-template-parser-go/hugo/config"
+  "github.com/erquhart/netlify-cms-template-parser-go/hugo/hugolib"
+  "github.com/erquhart/netlify-cms-template-parser-go/render"
+)
+
+// This is the WebAssembly counterpart of main.go. It exposes the same
+// goTemplateParser API through syscall/js, converting values to and from JS
+// the way GopherJS does. As a Go panic inside a JS callback would stop the
+// program, functions that throw under GopherJS return their error through
+// thrown instead, and are wrapped with throwing to throw it on the JS side.
+
+func main() {
+  api := exports(render.DefaultEnvironment())
+  api["createEnvironment"] = method(1, func(args []js.Value) interface{} {
+    return createEnvironment(args[0])
+  })
+
+  js.Global().Set("goTemplateParser", api)
+
+  // The exported functions are called back from JS for as long as the page
+  // lives.
+  select {}
+}
+
+// jsEnvironment exposes the functions of a render.Environment to JS.
+type jsEnvironment struct {
+  env *render.Environment
+}
+
+// exports returns the API of env as exported to JS.
+func exports(env *render.Environment) map[string]interface{} {
+  e := &jsEnvironment{env: env}
+  return map[string]interface{}{
+    "analyze": method(2, func(args []js.Value) interface{} {
+      return jsValue(e.analyze(args[0].String(), args[1]))
+    }),
+    "compile": method(3, func(args []js.Value) interface{} {
+      return jsValue(e.compile(args[0], args[1].String(), args[2]))
+    }),
+    "compileEntry": method(3, func(args []js.Value) interface{} {
+      return jsValue(e.compileEntry(args[0], args[1], args[2]))
+    }),
+    "compileStream": method(4, func(args []js.Value) interface{} {
+      return e.compileStream(args[0], args[1].String(), args[2], args[3])
+    }),
+    "config": configObject(env.Config()),
+    "lint": method(3, func(args []js.Value) interface{} {
+      return jsValue(e.lint(args[0].String(), args[1], args[2]))
+    }),
+    "prepare": method(2, func(args []js.Value) interface{} {
+      return e.prepare(args[0].String(), args[1])
+    }),
+    "registerFunction": throwing(method(2, func(args []js.Value) interface{} {
+      return e.registerFunction(args[0].String(), args[1])
+    })),
+    "registerLayouts": method(1, func(args []js.Value) interface{} {
+      e.registerLayouts(args[0])
+      return nil
+    }),
+    "registerPartial": method(2, func(args []js.Value) interface{} {
+      e.env.RegisterPartial(args[0].String(), args[1].String())
+      return nil
+    }),
+    "removeFunction": method(1, func(args []js.Value) interface{} {
+      e.env.RemoveFunction(args[0].String())
+      return nil
+    }),
+    "removeLayout": method(1, func(args []js.Value) interface{} {
+      env.RemoveLayout(args[0].String())
+      return nil
+    }),
+    "removePartial": method(1, func(args []js.Value) interface{} {
+      e.env.RemovePartial(args[0].String())
+      return nil
+    }),
+    "resolveLayout": method(2, func(args []js.Value) interface{} {
+      return jsValue(e.resolveLayout(args[0], args[1]))
+    }),
+    "scratch": scratchObject(env.Scratch()),
+  }
+}
+
+// createEnvironment returns a new environment with the same API as
+// goTemplateParser; see createEnvironment in main.go.
+func createEnvironment(options js.Value) map[string]interface{} {
+  return exports(newEnvironment(goValue(options)))
+}
+
+// compile renders tmpl against data and returns an object holding the
+// rendered html and an array of errors; see compile in main.go.
+func (e *jsEnvironment) compile(data js.Value, tmpl string, options js.Value) map[string]interface{} {
+  result, _ := e.env.Render(goValue(data), tmpl, optionsFromValue(goValue(options)))
+  return resultToMap(result)
+}
+
+// compileStream renders tmpl against data, passing the output to onChunk in
+// chunks as it is produced; see compileStream in main.go.
+func (e *jsEnvironment) compileStream(data js.Value, tmpl string, onChunk js.Value, options js.Value) js.Value {
+  return streamJS(e.env.Prepare(tmpl, optionsFromValue(goValue(options))), data, onChunk, options)
+}
+
+// streamJS renders p against data in a goroutine, delivering the output to
+// onChunk. Once the render is done, the cancel method is released and
+// replaced by one doing nothing.
+func streamJS(p *render.Prepared, data js.Value, onChunk js.Value, options js.Value) js.Value {
+  ctx, cancel := context.WithCancel(context.Background())
+  var resolve js.Value
+  executor := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+    resolve = args[0]
+    return nil
+  })
+  done := js.Global().Get("Promise").New(executor)
+  executor.Release()
+
+  cancelFunc := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+    cancel()
+    return nil
+  })
+  stream := js.Global().Get("Object").New()
+  stream.Set("done", done)
+  stream.Set("cancel", cancelFunc)
+
+  v, size := goValue(data), chunkSizeFromValue(goValue(options))
+  go func() {
+    defer cancel()
+    result := renderStream(ctx, p, v, size, func(chunk string) error {
+      return deliverChunk(ctx, onChunk, chunk)
+    })
+    stream.Set("cancel", js.Global().Get("Function").New())
+    cancelFunc.Release()
+    resolve.Invoke(jsValue(result))
+  }()
+
+  return stream
+}
+
+// deliverChunk calls onChunk with chunk and waits for the promise it
+// returns, if any, to settle, or for ctx to be done.
+func deliverChunk(ctx context.Context, onChunk js.Value, chunk string) (err error) {
+  defer func() {
+    if r := recover(); r != nil {
+      jsErr, ok := r.(js.Error)
+      if !ok {
+        panic(r)
+      }
+      err = jsErr
+    }
+  }()
+
+  settled := make(chan error, 1)
+  fulfilled := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+    settled <- nil
+    return nil
+  })
+  rejected := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+    settled <- js.Error{Value: args[0]}
+    return nil
+  })
+  defer fulfilled.Release()
+  defer rejected.Release()
+  js.Global().Get("Promise").Call("resolve", onChunk.Invoke(chunk)).Call("then", fulfilled, rejected)
+
+  select {
+  case err := <-settled:
+    return err
+  case <-ctx.Done():
+    return ctx.Err()
+  }
+}
+
+// prepare parses tmpl once and returns a handle whose render method executes
+// it against new data without parsing it again.
+func (e *jsEnvironment) prepare(tmpl string, options js.Value) map[string]interface{} {
+  return handle(e.env.Prepare(tmpl, optionsFromValue(goValue(options))))
+}
+
+// compileEntry renders data through the registered layout Hugo would choose
+// for the entry described by descriptor.
+func (e *jsEnvironment) compileEntry(data js.Value, descriptor js.Value, options js.Value) map[string]interface{} {
+  result, _ := e.env.RenderEntry(goValue(data), descriptorFromValue(goValue(descriptor)), optionsFromValue(goValue(options)))
+  return resultToMap(result)
+}
+
+// resolveLayout returns the names of the layout and base template Hugo
+// would choose for descriptor.
+func (e *jsEnvironment) resolveLayout(descriptor js.Value, options js.Value) map[string]interface{} {
+  return resolveLayoutToMap(e.env, descriptorFromValue(goValue(descriptor)), optionsFromValue(goValue(options)).Format)
+}
+
+// handle wraps p in an object with render, patch, reset, stream and release
+// methods; see handle in main.go. release frees the functions of the
+// handle, which are not garbage collected.
+func handle(p *render.Prepared) map[string]interface{} {
+  patcher := render.NewPatcher(p)
+  funcs := map[string]js.Func{
+    "render": method(1, func(args []js.Value) interface{} {
+      result, _ := p.Render(goValue(args[0]))
+      return jsValue(resultToMap(result))
+    }),
+    "patch": method(1, func(args []js.Value) interface{} {
+      result, _ := patcher.Render(goValue(args[0]))
+      return jsValue(resultToMap(result))
+    }),
+    "reset": method(0, func(args []js.Value) interface{} {
+      patcher.Reset()
+      return nil
+    }),
+    "stream": method(3, func(args []js.Value) interface{} {
+      return streamJS(p, args[0], args[1], args[2])
+    }),
+  }
+
+  h := make(map[string]interface{}, len(funcs)+1)
+  for name, f := range funcs {
+    h[name] = f
+  }
+  var release js.Func
+  release = method(0, func(args []js.Value) interface{} {
+    patcher.Reset()
+    for _, f := range funcs {
+      f.Release()
+    }
+    release.Release()
+    return nil
+  })
+  h["release"] = release
+  return h
+}
+
+// analyze returns the fields, functions and partials tmpl refers to.
+func (e *jsEnvironment) analyze(tmpl string, options js.Value) map[string]interface{} {
+  return analyzeToMap(e.env, tmpl, optionsFromValue(goValue(options)))
+}
+
+// lint checks tmpl against schema and the functions available to templates.
+func (e *jsEnvironment) lint(tmpl string, schema js.Value, options js.Value) map[string]interface{} {
+  return lintToMap(e.env, tmpl, goValue(schema), optionsFromValue(goValue(options)))
+}
+
+// registerLayouts adds every file of a Hugo layouts tree, given as an object
+// mapping paths to their source.
+func (e *jsEnvironment) registerLayouts(files js.Value) {
+  keys := js.Global().Get("Object").Call("keys", files)
+  for i := 0; i < keys.Length(); i++ {
+    name := keys.Index(i).String()
+    e.env.RegisterLayout(name, files.Get(name).String())
+  }
+}
+
+// registerFunction makes the JS function fn available to templates under
+// name. It throws an Error if the name cannot be registered.
+func (e *jsEnvironment) registerFunction(name string, fn js.Value) interface{} {
+  if err := e.env.RegisterFunction(name, jsFunc(fn)); err != nil {
+    return thrown(err)
+  }
+  return nil
+}
+
+// jsFunc wraps fn for use in a FuncMap.
+func jsFunc(fn js.Value) func(args ...interface{}) (interface{}, error) {
+  return func(args ...interface{}) (result interface{}, err error) {
+    defer func() {
+      if r := recover(); r != nil {
+        jsErr, ok := r.(js.Error)
+        if !ok {
+          panic(r)
+        }
+        err = jsErr
+      }
+    }()
+    values := make([]interface{}, len(args))
+    for i, arg := range args {
+      values[i] = jsValue(arg)
+    }
+    return goValue(fn.Invoke(values...)), nil
+  }
+}
+
+// configObject exposes the methods of c to JS under their Go names.
+func configObject(c config.Provider) map[string]interface{} {
+  return map[string]interface{}{
+    "Get": method(1, func(args []js.Value) interface{} {
+      return jsValue(c.Get(args[0].String()))
+    }),
+    "GetBool": method(1, func(args []js.Value) interface{} {
+      return c.GetBool(args[0].String())
+    }),
+    "GetInt": method(1, func(args []js.Value) interface{} {
+      return c.GetInt(args[0].String())
+    }),
+    "GetString": method(1, func(args []js.Value) interface{} {
+      return c.GetString(args[0].String())
+    }),
+    "GetStringMap": method(1, func(args []js.Value) interface{} {
+      return jsValue(c.GetStringMap(args[0].String()))
+    }),
+    "GetStringMapString": method(1, func(args []js.Value) interface{} {
+      return jsValue(c.GetStringMapString(args[0].String()))
+    }),
+    "IsSet": method(1, func(args []js.Value) interface{} {
+      return c.IsSet(args[0].String())
+    }),
+    "Set": method(2, func(args []js.Value) interface{} {
+      c.Set(args[0].String(), goValue(args[1]))
+      return nil
+    }),
+  }
+}
+
+// scratchObject exposes the methods of s to JS under their Go names, along
+// with snapshot and restore; see scratchObject in main.go. Methods of s
+// failing with an error return it as an Error, while snapshot and restore
+// throw theirs as they do under GopherJS.
+func scratchObject(s *hugolib.Scratch) map[string]interface{} {
+  return map[string]interface{}{
+    "Add": method(2, func(args []js.Value) interface{} {
+      return jsResult(s.Add(args[0].String(), goValue(args[1])))
+    }),
+    "AddUnique": method(2, func(args []js.Value) interface{} {
+      return jsResult(s.AddUnique(args[0].String(), goValue(args[1])))
+    }),
+    "Delete": method(1, func(args []js.Value) interface{} {
+      return s.Delete(args[0].String())
+    }),
+    "DeleteInMap": method(2, func(args []js.Value) interface{} {
+      return jsResult(s.DeleteInMap(args[0].String(), goValues(args[1:])...))
+    }),
+    "Get": method(1, func(args []js.Value) interface{} {
+      return jsValue(s.Get(args[0].String()))
+    }),
+    "GetSortedMapValues": method(1, func(args []js.Value) interface{} {
+      return jsResult(s.GetSortedMapValues(args[0].String()))
+    }),
+    "Intersect": method(2, func(args []js.Value) interface{} {
+      return jsResult(s.Intersect(args[0].String(), goValue(args[1])))
+    }),
+    "Set": method(2, func(args []js.Value) interface{} {
+      return s.Set(args[0].String(), goValue(args[1]))
+    }),
+    "Scope": method(0, func(args []js.Value) interface{} {
+      return scratchObject(s.Scope())
+    }),
+    "SetInMap": method(3, func(args []js.Value) interface{} {
+      return jsResult(s.SetInMap(args[0].String(), goValues(args[1:])...))
+    }),
+    "Subtract": method(2, func(args []js.Value) interface{} {
+      return jsResult(s.Subtract(args[0].String(), goValue(args[1])))
+    }),
+    "Values": method(0, func(args []js.Value) interface{} {
+      return jsValue(s.Values())
+    }),
+    "restore": throwing(method(1, func(args []js.Value) interface{} {
+      data := js.Global().Get("JSON").Call("stringify", args[0]).String()
+      if err := s.UnmarshalJSON([]byte(data)); err != nil {
+        return thrown(err)
+      }
+      return nil
+    })),
+    "snapshot": throwing(method(0, func(args []js.Value) interface{} {
+      data, err := s.MarshalJSON()
+      if err != nil {
+        return thrown(err)
+      }
+      return js.Global().Get("JSON").Call("parse", string(data))
+    })),
+  }
+}
+
+// jsResult returns err as an Error if it is set, or else v converted by
+// jsValue.
+func jsResult(v interface{}, err error) interface{} {
+  if err != nil {
+    return js.Global().Get("Error").New(err.Error())
+  }
+  return jsValue(v)
+}
+
+// rethrow is a JS function wrapping another so that an exception it returns
+// through rethrow.thrown is thrown.
+var rethrow = js.Global().Get("Function").New(`
+  class Thrown {
+    constructor(error) { this.error = error; }
+  }
+  const rethrow = f => function() {
+    const result = f.apply(this, arguments);
+    if (result instanceof Thrown) throw result.error;
+    return result;
+  };
+  rethrow.thrown = message => new Thrown(new Error(message));
+  return rethrow;
+`).Invoke()
+
+// throwing wraps f so that an error it returns through thrown is thrown in
+// JS, as the GopherJS build does by panicking.
+func throwing(f js.Func) js.Value {
+  return rethrow.Invoke(f)
+}
+
+// thrown returns err in a form throwing throws as an Error.
+func thrown(err error) js.Value {
+  return rethrow.Call("thrown", err.Error())
+}
+
+// method wraps f as a JS function taking at least arity arguments. Missing
+// arguments are undefined.
+func method(arity int, f func(args []js.Value) interface{}) js.Func {
+  return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+    if len(args) < arity {
+      padded := make([]js.Value, arity)
+      copy(padded, args)
+      args = padded
+    }
+    return f(args)
+  })
+}
+
+// goValue converts v to a Go value as GopherJS does: arrays become
+// []interface{}, dates time.Time, numbers float64, functions template
+// functions and other objects map[string]interface{}.
+func goValue(v js.Value) interface{} {
+  switch v.Type() {
+  case js.TypeUndefined, js.TypeNull:
+    return nil
+  case js.TypeBoolean:
+    return v.Bool()
+  case js.TypeNumber:
+    return v.Float()
+  case js.TypeString:
+    return v.String()
+  case js.TypeFunction:
+    return jsFunc(v)
+  case js.TypeObject:
+    if js.Global().Get("Array").Call("isArray", v).Bool() {
+      s := make([]interface{}, v.Length())
+      for i := range s {
+        s[i] = goValue(v.Index(i))
+      }
+      return s
+    }
+    if v.InstanceOf(js.Global().Get("Date")) {
+      return time.UnixMilli(int64(v.Call("getTime").Float()))
+    }
+    keys := js.Global().Get("Object").Call("keys", v)
+    m := make(map[string]interface{}, keys.Length())
+    for i := 0; i < keys.Length(); i++ {
+      key := keys.Index(i).String()
+      m[key] = goValue(v.Get(key))
+    }
+    return m
+  default:
+    return v.String()
+  }
+}
+
+// goValues converts each of values with goValue.
+func goValues(values []js.Value) []interface{} {
+  s := make([]interface{}, len(values))
+  for i, v := range values {
+    s[i] = goValue(v)
+  }
+  return s
+}
+
+// jsValue converts v to a value js.ValueOf accepts: slices and arrays
+// become []interface{}, maps and structs map[string]interface{}, times
+// dates, and named basic types their underlying type. A map, slice or
+// pointer found again inside itself becomes null, so that values referring
+// to themselves, such as a scratch map holding itself, can be converted.
+func jsValue(v interface{}) interface{} {
+  return (&converter{seen: make(map[reference]bool)}).value(v)
+}
+
+// reference identifies a map, slice or pointer: slices sharing an array are
+// the same only if they also have the same length.
+type reference struct {
+  ptr uintptr
+  typ reflect.Type
+  len int
+}
+
+// converter converts values for jsValue, keeping the references being
+// converted.
+type converter struct {
+  seen map[reference]bool
+}
+
+// enter marks rv as being converted and reports whether it already was, in
+// which case it must not be converted again.
+func (c *converter) enter(rv reflect.Value) (ref reference, cycle bool) {
+  ref = reference{ptr: rv.Pointer(), typ: rv.Type()}
+  if rv.Kind() == reflect.Slice {
+    ref.len = rv.Len()
+  }
+  if c.seen[ref] {
+    return ref, true
+  }
+  c.seen[ref] = true
+  return ref, false
+}
+
+func (c *converter) value(v interface{}) interface{} {
+  switch v := v.(type) {
+  case nil, js.Value, js.Func:
+    return v
+  case time.Time:
+    return js.Global().Get("Date").New(float64(v.UnixNano()) / float64(time.Millisecond))
+  }
+
+  rv := reflect.ValueOf(v)
+  switch rv.Kind() {
+  case reflect.Bool:
+    return rv.Bool()
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    return rv.Int()
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+    return rv.Uint()
+  case reflect.Float32, reflect.Float64:
+    return rv.Float()
+  case reflect.String:
+    return rv.String()
+  case reflect.Slice, reflect.Array:
+    if rv.Kind() == reflect.Slice {
+      if rv.IsNil() {
+        return nil
+      }
+      ref, cycle := c.enter(rv)
+      if cycle {
+        return nil
+      }
+      defer delete(c.seen, ref)
+    }
+    s := make([]interface{}, rv.Len())
+    for i := range s {
+      s[i] = c.value(rv.Index(i).Interface())
+    }
+    return s
+  case reflect.Map:
+    if rv.IsNil() {
+      return nil
+    }
+    ref, cycle := c.enter(rv)
+    if cycle {
+      return nil
+    }
+    defer delete(c.seen, ref)
+    m := make(map[string]interface{}, rv.Len())
+    iter := rv.MapRange()
+    for iter.Next() {
+      m[fmt.Sprint(iter.Key().Interface())] = c.value(iter.Value().Interface())
+    }
+    return m
+  case reflect.Ptr:
+    if rv.IsNil() {
+      return nil
+    }
+    ref, cycle := c.enter(rv)
+    if cycle {
+      return nil
+    }
+    defer delete(c.seen, ref)
+    return c.value(rv.Elem().Interface())
+  case reflect.Interface:
+    if rv.IsNil() {
+      return nil
+    }
+    return c.value(rv.Elem().Interface())
+  case reflect.Struct:
+    m := make(map[string]interface{}, rv.NumField())
+    for i := 0; i < rv.NumField(); i++ {
+      if f := rv.Type().Field(i); f.PkgPath == "" {
+        m[f.Name] = c.value(rv.Field(i).Interface())
+      }
+    }
+    return m
+  default:
+    return nil
+  }
+}
+
+func isUndefined(o js.Value) bool {
+  return o.IsUndefined() || o.IsNull()
+}
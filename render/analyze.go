@@ -0,0 +1,77 @@
+package render
+
+import (
+  "text/template/parse"
+
+  "github.com/erquhart/netlify-cms-template-parser-go/analysis"
+)
+
+// Analyze reports the fields, functions and partials tmpl refers to, along
+// with those of its base and the partials it calls. Unknown functions do not
+// stop the analysis.
+func (env *Environment) Analyze(tmpl string, opts Options) (*analysis.Report, error) {
+  trees, entry, err := env.parseForAnalysis(tmpl, opts)
+  if err != nil {
+    return nil, err
+  }
+  return analysis.Analyze(trees, entry), nil
+}
+
+// Lint checks tmpl, its base and the partials it calls against schema and
+// the functions available to templates. schema may be nil.
+func (env *Environment) Lint(tmpl string, schema *analysis.Schema, opts Options) ([]*analysis.Problem, error) {
+  trees, entry, err := env.parseForAnalysis(tmpl, opts)
+  if err != nil {
+    return nil, err
+  }
+
+  custom, _ := env.custom.snapshot()
+  known := make(map[string]interface{}, len(env.funcs)+len(custom))
+  for name, fn := range env.funcs {
+    known[name] = fn
+  }
+  for name, fn := range custom {
+    known[name] = fn
+  }
+  return analysis.Lint(trees, entry, schema, known), nil
+}
+
+// parseForAnalysis parses tmpl, its base and the registered partials the
+// same way Prepare does, returning the trees and the name of the template
+// that would be executed. Each partial is parsed on its own, and its trees
+// only added under the names tmpl and its base leave free; partials that
+// fail to parse are left out.
+func (env *Environment) parseForAnalysis(tmpl string, opts Options) (map[string]*parse.Tree, string, error) {
+  opts = opts.withDefaults()
+  trees := make(map[string]*parse.Tree)
+
+  if opts.Base != "" {
+    if err := analysis.Parse(trees, opts.BaseName, opts.Base, opts.LeftDelim, opts.RightDelim); err != nil {
+      return nil, "", err
+    }
+  }
+
+  if err := analysis.Parse(trees, opts.Name, tmpl, opts.LeftDelim, opts.RightDelim); err != nil {
+    return nil, "", err
+  }
+
+  sources, _ := env.partials.snapshot()
+  for name, source := range sources {
+    partialTrees := make(map[string]*parse.Tree)
+    if err := analysis.Parse(partialTrees, name, source, "", ""); err != nil {
+      continue
+    }
+    for treeName, tree := range partialTrees {
+      if _, found := trees[treeName]; !found {
+        trees[treeName] = tree
+      }
+    }
+  }
+
+  entry := opts.Name
+  if opts.Base != "" && isEmptyTree(trees[opts.Name]) {
+    entry = opts.BaseName
+  }
+  return trees, entry, nil
+}
+
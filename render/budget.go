@@ -0,0 +1,288 @@
+package render
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "io"
+  "strconv"
+  "strings"
+  "text/template/parse"
+  "time"
+)
+
+const (
+  // stepFunc is the name of the function instrument inserts into templates
+  // to count steps. Function names starting with an underscore are
+  // reserved.
+  stepFunc = "_step"
+
+  // callFunc is the name of the function instrument inserts around
+  // {{ template }} calls to track how deeply they nest.
+  callFunc = "_call"
+)
+
+// DefaultLimits are the limits applied to a render unless overridden.
+var DefaultLimits = Limits{
+  Timeout:         5 * time.Second,
+  MaxSteps:        1000000,
+  MaxPartialDepth: 32,
+  MaxOutputBytes:  10 << 20,
+}
+
+// Limits bound the work a single render may do, so that a template with an
+// accidental huge range or recursive partial cannot hang the editor. A zero
+// field uses the value from DefaultLimits; a negative one disables the limit.
+type Limits struct {
+  // Timeout is the wall time a render may take. As execution is
+  // synchronous, it is checked at every step rather than enforced by a
+  // timer.
+  Timeout time.Duration
+
+  // MaxSteps is the number of actions evaluated, range iterations,
+  // partials included and templates called.
+  MaxSteps int
+
+  // MaxPartialDepth is how deeply partials and {{ template }} calls may
+  // nest.
+  MaxPartialDepth int
+
+  // MaxOutputBytes is the size of the rendered output.
+  MaxOutputBytes int
+}
+
+// withDefaults fills in the zero fields of l from DefaultLimits.
+func (l Limits) withDefaults() Limits {
+  if l.Timeout == 0 {
+    l.Timeout = DefaultLimits.Timeout
+  }
+  if l.MaxSteps == 0 {
+    l.MaxSteps = DefaultLimits.MaxSteps
+  }
+  if l.MaxPartialDepth == 0 {
+    l.MaxPartialDepth = DefaultLimits.MaxPartialDepth
+  }
+  if l.MaxOutputBytes == 0 {
+    l.MaxOutputBytes = DefaultLimits.MaxOutputBytes
+  }
+  return l
+}
+
+// LimitError is returned when a render exceeds one of its Limits.
+type LimitError struct {
+  // Limit is one of "timeout", "steps", "partialDepth" or "outputBytes".
+  Limit string
+  Max   interface{}
+  // Calls are the names of the partials and templates being executed when
+  // the limit was exceeded, outermost first, if it was exceeded in one.
+  Calls []string
+}
+
+func (e *LimitError) Error() string {
+  msg := fmt.Sprintf("limit exceeded: %s (%v)", e.Limit, e.Max)
+  if len(e.Calls) > 0 {
+    msg += " in " + strings.Join(e.Calls, " > ")
+  }
+  return msg
+}
+
+// budget tracks the work done by a single render against its limits.
+type budget struct {
+  ctx      context.Context
+  limits   Limits
+  deadline time.Time
+  steps    int
+  calls    []string
+  written  int
+}
+
+func newBudget(ctx context.Context, limits Limits) *budget {
+  limits = limits.withDefaults()
+  b := &budget{ctx: ctx, limits: limits}
+  if limits.Timeout > 0 {
+    b.deadline = time.Now().Add(limits.Timeout)
+  }
+  return b
+}
+
+// step counts one step of execution, stopping it if the budget's context is
+// done. It is bound to stepFunc and always returns false, so that the
+// instrumented {{ if }} renders nothing.
+func (b *budget) step() (bool, error) {
+  if err := b.ctx.Err(); err != nil {
+    return false, err
+  }
+  b.steps++
+  if b.limits.MaxSteps > 0 && b.steps > b.limits.MaxSteps {
+    return false, &LimitError{Limit: "steps", Max: b.limits.MaxSteps}
+  }
+  if !b.deadline.IsZero() && time.Now().After(b.deadline) {
+    return false, &LimitError{Limit: "timeout", Max: b.limits.Timeout}
+  }
+  return false, nil
+}
+
+// include wraps a call to the named partial so that it counts as a step and
+// is subject to the partial depth limit. A LimitError raised by the partial
+// is returned as is rather than wrapped by every partial it is nested in.
+func (b *budget) include(name string, fn func() (interface{}, error)) (interface{}, error) {
+  if err := b.enter(name); err != nil {
+    return nil, err
+  }
+  defer b.leave()
+
+  v, err := fn()
+  var limitErr *LimitError
+  if errors.As(err, &limitErr) {
+    return nil, limitErr
+  }
+  return v, err
+}
+
+// call is bound to callFunc. "enter" counts a {{ template }} call to the
+// named template as a step and as one level of nesting, which "leave"
+// undoes once it returns. It always returns false, so that the instrumented
+// {{ if }} renders nothing.
+func (b *budget) call(op, name string) (bool, error) {
+  switch op {
+  case "enter":
+    return false, b.enter(strconv.Quote(name))
+  case "leave":
+    b.leave()
+    return false, nil
+  }
+  return false, fmt.Errorf("%s: unknown operation %q", callFunc, op)
+}
+
+// enter counts a step and pushes the named partial or template on the
+// calls, failing if they nest too deeply. LimitErrors are given the calls
+// they were raised in.
+func (b *budget) enter(name string) error {
+  b.calls = append(b.calls, name)
+  _, err := b.step()
+  if err == nil && b.limits.MaxPartialDepth > 0 && len(b.calls) > b.limits.MaxPartialDepth {
+    err = &LimitError{Limit: "partialDepth", Max: b.limits.MaxPartialDepth}
+  }
+  if err != nil {
+    if limitErr, ok := err.(*LimitError); ok {
+      limitErr.Calls = append([]string(nil), b.calls...)
+    }
+    b.leave()
+  }
+  return err
+}
+
+func (b *budget) leave() {
+  b.calls = b.calls[:len(b.calls)-1]
+}
+
+// writer returns a writer that fails once the output limit is reached.
+func (b *budget) writer(w io.Writer) io.Writer {
+  return &budgetWriter{w: w, b: b}
+}
+
+type budgetWriter struct {
+  w io.Writer
+  b *budget
+}
+
+func (w *budgetWriter) Write(p []byte) (int, error) {
+  max := w.b.limits.MaxOutputBytes
+  if max > 0 && w.b.written+len(p) > max {
+    n, _ := w.w.Write(p[:max-w.b.written])
+    w.b.written += n
+    return n, &LimitError{Limit: "outputBytes", Max: max}
+  }
+  n, err := w.w.Write(p)
+  w.b.written += n
+  return n, err
+}
+
+// instrument inserts a call to stepFunc before every action and at the start
+// of every range iteration in tree, and calls to callFunc around every
+// {{ template }} call, as text/template has no hooks of its own to count
+// them. The calls sit in an empty {{ if }}, which renders nothing and is
+// left alone by html/template's escaper.
+func instrument(tree *parse.Tree) {
+  if tree != nil {
+    instrumentList(tree, tree.Root)
+  }
+}
+
+func instrumentList(tree *parse.Tree, list *parse.ListNode) {
+  if list == nil {
+    return
+  }
+
+  nodes := make([]parse.Node, 0, len(list.Nodes))
+  for _, node := range list.Nodes {
+    switch n := node.(type) {
+    case *parse.ActionNode:
+      nodes = append(nodes, stepNode(tree, n.Position()))
+    case *parse.TemplateNode:
+      nodes = append(nodes, callNode(tree, n.Position(), "enter", n.Name), n, callNode(tree, n.Position(), "leave", n.Name))
+      continue
+    case *parse.IfNode:
+      instrumentList(tree, n.List)
+      instrumentList(tree, n.ElseList)
+    case *parse.WithNode:
+      instrumentList(tree, n.List)
+      instrumentList(tree, n.ElseList)
+    case *parse.RangeNode:
+      instrumentList(tree, n.List)
+      instrumentList(tree, n.ElseList)
+      if n.List != nil {
+        n.List.Nodes = append([]parse.Node{stepNode(tree, n.Position())}, n.List.Nodes...)
+      }
+    }
+    nodes = append(nodes, node)
+  }
+  list.Nodes = nodes
+}
+
+// stepNode returns the node for {{ if _step }}{{ end }}, positioned at pos so
+// that limit errors point at the action that follows it.
+func stepNode(tree *parse.Tree, pos parse.Pos) parse.Node {
+  return markerNode(pos, parse.NewIdentifier(stepFunc).SetTree(tree).SetPos(pos))
+}
+
+// callNode returns the node for {{ if _call op name }}{{ end }}, positioned
+// at pos.
+func callNode(tree *parse.Tree, pos parse.Pos, op, name string) parse.Node {
+  return markerNode(pos,
+    parse.NewIdentifier(callFunc).SetTree(tree).SetPos(pos),
+    &parse.StringNode{NodeType: parse.NodeString, Pos: pos, Quoted: strconv.Quote(op), Text: op},
+    &parse.StringNode{NodeType: parse.NodeString, Pos: pos, Quoted: strconv.Quote(name), Text: name},
+  )
+}
+
+// markerPrototype is copied by markerNode. Branch nodes can only be printed
+// with the tree they were parsed in, which cannot be set from outside the
+// parse package.
+var markerPrototype = func() *parse.IfNode {
+  t := parse.New("marker")
+  t.Mode = parse.SkipFuncCheck
+  if _, err := t.Parse("{{if marker}}{{end}}", "", "", make(map[string]*parse.Tree)); err != nil {
+    panic(err)
+  }
+  return t.Root.Nodes[0].(*parse.IfNode)
+}()
+
+// markerNode returns an empty {{ if }} node whose condition is a command
+// made up of args, positioned at pos.
+func markerNode(pos parse.Pos, args ...parse.Node) parse.Node {
+  n := markerPrototype.Copy().(*parse.IfNode)
+  n.Pos = pos
+  n.Pipe = &parse.PipeNode{
+    NodeType: parse.NodePipe,
+    Pos:      pos,
+    Cmds:     []*parse.CommandNode{commandNode(pos, args...)},
+  }
+  n.List.Pos = pos
+  return n
+}
+
+// commandNode returns a command node made up of args, positioned at pos.
+func commandNode(pos parse.Pos, args ...parse.Node) *parse.CommandNode {
+  return &parse.CommandNode{NodeType: parse.NodeCommand, Pos: pos, Args: args}
+}
@@ -0,0 +1,88 @@
+package render
+
+import (
+  "errors"
+  "strings"
+  "testing"
+)
+
+func TestLimits(t *testing.T) {
+  env := NewEnvironment(nil)
+  env.RegisterPartial("loop.html", `{{ partial "loop.html" . }}`)
+  env.RegisterPartial("outer.html", `{{ partial "loop.html" . }}`)
+
+  tests := []struct {
+    name   string
+    tmpl   string
+    limits Limits
+    limit  string
+    calls  []string
+  }{
+    {
+      name:   "recursive partial",
+      tmpl:   `{{ partial "outer.html" . }}`,
+      limits: Limits{MaxPartialDepth: 3},
+      limit:  "partialDepth",
+      calls:  []string{"outer.html", "loop.html", "loop.html", "loop.html"},
+    },
+    {
+      name:   "recursive template",
+      tmpl:   `{{ define "r" }}{{ template "r" . }}{{ end }}{{ template "r" . }}`,
+      limits: Limits{MaxPartialDepth: 2},
+      limit:  "partialDepth",
+      calls:  []string{`"r"`, `"r"`, `"r"`},
+    },
+    {
+      name:   "template calls are steps",
+      tmpl:   `{{ define "e" }}{{ end }}{{ template "e" }}{{ template "e" }}{{ template "e" }}`,
+      limits: Limits{MaxSteps: 2},
+      limit:  "steps",
+      calls:  []string{`"e"`},
+    },
+    {
+      name:   "steps in partial",
+      tmpl:   `{{ partial "outer.html" . }}`,
+      limits: Limits{MaxSteps: 3},
+      limit:  "steps",
+      calls:  []string{"outer.html", "loop.html"},
+    },
+  }
+
+  for _, test := range tests {
+    result, err := env.Render(nil, test.tmpl, Options{Limits: test.limits})
+    if err == nil {
+      t.Errorf("%s: no error", test.name)
+      continue
+    }
+    if n := strings.Count(err.Error(), "error calling partial"); n > 1 {
+      t.Errorf("%s: error wrapped %d times: %v", test.name, n, err)
+    }
+
+    var limitErr *LimitError
+    if !errors.As(err, &limitErr) {
+      t.Errorf("%s: got %v, want a LimitError", test.name, err)
+      continue
+    }
+    if limitErr.Limit != test.limit || strings.Join(limitErr.Calls, " ") != strings.Join(test.calls, " ") {
+      t.Errorf("%s: got %s in %q, want %s in %q", test.name, limitErr.Limit, limitErr.Calls, test.limit, test.calls)
+    }
+    if e := result.Errors[0]; e.Limit != test.limit || e.Message != limitErr.Error() {
+      t.Errorf("%s: got template error %q (%s), want %q", test.name, e.Message, e.Limit, limitErr.Error())
+    }
+  }
+}
+
+func TestLimitsNotExceeded(t *testing.T) {
+  env := NewEnvironment(nil)
+  env.RegisterPartial("item.html", `<li>{{ . }}</li>`)
+
+  tmpl := `{{ define "list" }}<ul>{{ range . }}{{ partial "item.html" . }}{{ end }}</ul>{{ end }}{{ template "list" .items }}{{ template "list" .items }}`
+  data := map[string]interface{}{"items": []interface{}{"a", "b"}}
+  result, err := env.Render(data, tmpl, Options{Limits: Limits{MaxPartialDepth: 2}})
+  if err != nil {
+    t.Fatal(err)
+  }
+  if want := "<ul><li>a</li><li>b</li></ul><ul><li>a</li><li>b</li></ul>"; result.HTML != want {
+    t.Errorf("got %q, want %q", result.HTML, want)
+  }
+}
@@ -0,0 +1,81 @@
+package render
+
+import (
+  "container/list"
+  "crypto/sha256"
+  "encoding/hex"
+  "strconv"
+  "sync"
+)
+
+// templateCacheSize is the number of parsed templates kept by Render and
+// Prepare before the least recently used one is evicted.
+const templateCacheSize = 64
+
+// templateCache is a least recently used cache of prepared templates keyed
+// by a hash of everything that went into parsing them.
+type templateCache struct {
+  mu       sync.Mutex
+  capacity int
+  entries  map[string]*list.Element
+  order    *list.List
+}
+
+type cacheEntry struct {
+  key      string
+  prepared *Prepared
+}
+
+func newTemplateCache(capacity int) *templateCache {
+  return &templateCache{
+    capacity: capacity,
+    entries:  make(map[string]*list.Element),
+    order:    list.New(),
+  }
+}
+
+// get returns the prepared template stored under key, marking it as
+// recently used.
+func (c *templateCache) get(key string) (*Prepared, bool) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  el, found := c.entries[key]
+  if !found {
+    return nil, false
+  }
+  c.order.MoveToFront(el)
+  return el.Value.(*cacheEntry).prepared, true
+}
+
+// add stores p under key, evicting the least recently used entries once the
+// cache is over capacity.
+func (c *templateCache) add(key string, p *Prepared) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  if el, found := c.entries[key]; found {
+    el.Value.(*cacheEntry).prepared = p
+    c.order.MoveToFront(el)
+    return
+  }
+
+  c.entries[key] = c.order.PushFront(&cacheEntry{key: key, prepared: p})
+  for c.order.Len() > c.capacity {
+    el := c.order.Back()
+    c.order.Remove(el)
+    delete(c.entries, el.Value.(*cacheEntry).key)
+  }
+}
+
+// cacheKey hashes parts into a single key. Each part is length prefixed so
+// that different splits of the same text do not collide.
+func cacheKey(parts ...string) string {
+  h := sha256.New()
+  for _, part := range parts {
+    h.Write([]byte(strconv.Itoa(len(part))))
+    h.Write([]byte{':'})
+    h.Write([]byte(part))
+  }
+  return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,46 @@
+package render
+
+import "testing"
+
+func TestTemplateCache(t *testing.T) {
+  c := newTemplateCache(2)
+  a, b, d := &Prepared{}, &Prepared{}, &Prepared{}
+  c.add("a", a)
+  c.add("b", b)
+  if p, found := c.get("a"); !found || p != a {
+    t.Fatalf("get a: got %p %v, want %p", p, found, a)
+  }
+
+  // b is now the least recently used entry.
+  c.add("d", d)
+  if _, found := c.get("b"); found {
+    t.Error("b was not evicted")
+  }
+  for key, want := range map[string]*Prepared{"a": a, "d": d} {
+    if p, found := c.get(key); !found || p != want {
+      t.Errorf("get %s: got %p %v, want %p", key, p, found, want)
+    }
+  }
+}
+
+func TestCacheKey(t *testing.T) {
+  if cacheKey("ab", "c") == cacheKey("a", "bc") {
+    t.Error("different splits of the same text share a key")
+  }
+  if cacheKey("a", "b") != cacheKey("a", "b") {
+    t.Error("the same parts have different keys")
+  }
+}
+
+func TestPrepare(t *testing.T) {
+  env := NewEnvironment(nil)
+  p := env.Prepare("{{ .a }}", Options{})
+  if env.Prepare("{{ .a }}", Options{}).set != p.set {
+    t.Error("the same source was parsed again")
+  }
+  for _, a := range []string{"x", "y"} {
+    if result, err := p.Render(map[string]interface{}{"a": a}); result.HTML != a || err != nil {
+      t.Errorf("got %q %v, want %q", result.HTML, err, a)
+    }
+  }
+}
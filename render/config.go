@@ -0,0 +1,110 @@
+package render
+
+import (
+  "strings"
+  "sync"
+
+  "github.com/spf13/cast"
+)
+
+// siteConfig is a config.Provider backed by the values of a Hugo site
+// config. As in Hugo, keys are case insensitive and a dotted key such as
+// "params.author" reaches into nested maps.
+type siteConfig struct {
+  mu     sync.RWMutex
+  values map[string]interface{}
+}
+
+func newSiteConfig(values map[string]interface{}) *siteConfig {
+  c := &siteConfig{values: make(map[string]interface{})}
+  for key, value := range values {
+    c.Set(key, value)
+  }
+  return c
+}
+
+// Get returns the value of key, or nil if it is not set.
+func (c *siteConfig) Get(key string) interface{} {
+  c.mu.RLock()
+  defer c.mu.RUnlock()
+
+  var v interface{} = c.values
+  for _, part := range strings.Split(key, ".") {
+    m, ok := v.(map[string]interface{})
+    if !ok {
+      return nil
+    }
+    if v, ok = lookupFold(m, part); !ok {
+      return nil
+    }
+  }
+  return v
+}
+
+// Set sets key to value, creating the maps a dotted key reaches into.
+func (c *siteConfig) Set(key string, value interface{}) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  parts := strings.Split(key, ".")
+  m := c.values
+  for _, part := range parts[:len(parts)-1] {
+    next, ok := lookupFold(m, part)
+    child, isMap := next.(map[string]interface{})
+    if !ok || !isMap {
+      child = make(map[string]interface{})
+      setFold(m, part, child)
+    }
+    m = child
+  }
+  setFold(m, parts[len(parts)-1], value)
+}
+
+// IsSet reports whether key has a value.
+func (c *siteConfig) IsSet(key string) bool {
+  return c.Get(key) != nil
+}
+
+func (c *siteConfig) GetString(key string) string {
+  return cast.ToString(c.Get(key))
+}
+
+func (c *siteConfig) GetInt(key string) int {
+  return cast.ToInt(c.Get(key))
+}
+
+func (c *siteConfig) GetBool(key string) bool {
+  return cast.ToBool(c.Get(key))
+}
+
+func (c *siteConfig) GetStringMap(key string) map[string]interface{} {
+  return cast.ToStringMap(c.Get(key))
+}
+
+func (c *siteConfig) GetStringMapString(key string) map[string]string {
+  return cast.ToStringMapString(c.Get(key))
+}
+
+// lookupFold returns the value of m under key, matched case insensitively
+// if there is no exact match.
+func lookupFold(m map[string]interface{}, key string) (interface{}, bool) {
+  if v, found := m[key]; found {
+    return v, true
+  }
+  for k, v := range m {
+    if strings.EqualFold(k, key) {
+      return v, true
+    }
+  }
+  return nil, false
+}
+
+// setFold sets key in m, replacing a key differing only in case.
+func setFold(m map[string]interface{}, key string, value interface{}) {
+  for k := range m {
+    if k != key && strings.EqualFold(k, key) {
+      delete(m, k)
+    }
+  }
+  m[key] = value
+}
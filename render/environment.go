@@ -0,0 +1,91 @@
+package render
+
+import (
+  "html/template"
+
+  "github.com/erquhart/netlify-cms-template-parser-go/hugo/config"
+  "github.com/erquhart/netlify-cms-template-parser-go/hugo/hugolib"
+  "github.com/erquhart/netlify-cms-template-parser-go/hugo/tpl/partials"
+)
+
+// defaultEnvironment is the environment of the package level Render.
+var defaultEnvironment = NewEnvironment(nil)
+
+// DefaultEnvironment returns the environment used by Render.
+func DefaultEnvironment() *Environment {
+  return defaultEnvironment
+}
+
+// Environment holds everything templates are rendered with besides their
+// data: the functions, partials and layouts available to them, the site
+// configuration and a scratch. Each site managed by the CMS can have an
+// environment of its own, sharing no state with the others.
+type Environment struct {
+  funcs    template.FuncMap
+  partials *templateRegistry
+  layouts  *templateRegistry
+  custom   *funcRegistry
+  cache    *templateCache
+  config   config.Provider
+  scratch  *hugolib.Scratch
+}
+
+// NewEnvironment returns an empty environment configured with the values
+// of a Hugo site config, such as { title, baseURL, params }.
+func NewEnvironment(values map[string]interface{}) *Environment {
+  env := &Environment{
+    partials: newTemplateRegistry(partialName),
+    layouts:  newTemplateRegistry(layoutName),
+    cache:    newTemplateCache(templateCacheSize),
+    config:   newSiteConfig(values),
+    scratch:  hugolib.NewScratch(),
+  }
+  env.funcs = funcMap(partials.New(nil))
+  env.funcs["site"] = env.site
+  env.custom = newFuncRegistry(env.funcs)
+  return env
+}
+
+// site returns the site variables Hugo makes available to templates
+// through its site function, as read from the environment's config.
+func (env *Environment) site() map[string]interface{} {
+  return map[string]interface{}{
+    "BaseURL":      env.config.GetString("baseURL"),
+    "LanguageCode": env.config.GetString("languageCode"),
+    "Params":       env.config.GetStringMap("params"),
+    "Title":        env.config.GetString("title"),
+  }
+}
+
+// Config returns the site configuration of the environment.
+func (env *Environment) Config() config.Provider {
+  return env.config
+}
+
+// Scratch returns the scratch of the environment.
+func (env *Environment) Scratch() *hugolib.Scratch {
+  return env.scratch
+}
+
+// RegisterPartial makes source available to templates as the named partial,
+// e.g. "cards/post.html" for {{ partial "cards/post.html" . }}.
+func (env *Environment) RegisterPartial(name, source string) {
+  env.partials.register(name, source)
+}
+
+// RemovePartial unregisters the named partial.
+func (env *Environment) RemovePartial(name string) {
+  env.partials.remove(name)
+}
+
+// RegisterFunction makes fn available to templates under name. It fails if
+// fn is not a function returning a value and optionally an error, or if name
+// is not a valid identifier, is reserved or is that of a built-in function.
+func (env *Environment) RegisterFunction(name string, fn interface{}) error {
+  return env.custom.register(name, fn)
+}
+
+// RemoveFunction unregisters a function added with RegisterFunction.
+func (env *Environment) RemoveFunction(name string) {
+  env.custom.remove(name)
+}
@@ -0,0 +1,251 @@
+package render
+
+import (
+  "errors"
+  "fmt"
+  htmltemplate "html/template"
+  "regexp"
+  "strconv"
+  "strings"
+  texttemplate "text/template"
+)
+
+var (
+  // template: name:line:col: executing "name" at <action>: message
+  execErrorRe = regexp.MustCompile(`(?s)^template: (.*?):(\d+):(\d+): executing ".*?" at <(.*?)>: (.*)$`)
+  // template: name:line: message
+  parseErrorRe = regexp.MustCompile(`(?s)^template: (.*?):(\d+): (.*)$`)
+  // The token a parse error complains about, such as "}" in
+  // unexpected "}" in operand, or end in unexpected <end>.
+  tokenRe = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"|<(\w+)>`)
+
+  // The prefix text/template gives errors returned by the functions
+  // instrument and instrumentSources insert.
+  markerCallRe = regexp.MustCompile(`error calling (?:_step|_call|_src): `)
+  // The failing action of such an error when it is one they were inserted
+  // as.
+  markerActionRe = regexp.MustCompile(` at <_(?:step|call|src)\b[^>]*>`)
+  // The commands instrumentSources appends to pipelines, as printed.
+  markerCommandRe = regexp.MustCompile(` \| _src "\w+" \d+`)
+  // The wrapper instrumentSources puts around partial contexts, as printed,
+  // up to the context itself.
+  markerContextRe = regexp.MustCompile(`\(_src "ctx" \d+ `)
+)
+
+// TemplateError describes a single failure while parsing or executing a
+// template, in a form that can be shown next to the template source.
+type TemplateError struct {
+  Name string
+  Line int
+  // Column is the byte offset of the failing action or token within its
+  // line, as text/template reports it. Parse errors only carry a line, so
+  // their column and action are found from the token the error names; they
+  // are left empty for errors such as unexpected EOF that name none.
+  Column  int
+  Action  string
+  Message string
+  // Limit is the name of the exceeded limit if the render was stopped by a
+  // LimitError.
+  Limit string
+  Err   error
+}
+
+// Error returns the message of Err, without the functions instrument and
+// instrumentSources insert into templates.
+func (e *TemplateError) Error() string {
+  return stripMarkers(e.Err.Error())
+}
+
+func (e *TemplateError) Unwrap() error {
+  return e.Err
+}
+
+// NewTemplateError extracts the template name, position and failing action
+// from the errors returned by text/template and html/template.
+func NewTemplateError(err error) *TemplateError {
+  te := &TemplateError{Message: err.Error(), Err: err}
+
+  switch e := err.(type) {
+  case *htmltemplate.Error:
+    te.Name = e.Name
+    te.Line = e.Line
+    te.Message = stripMarkers(e.Description)
+    if e.Node != nil {
+      te.Action = stripMarkers(e.Node.String())
+    }
+    return te
+  case texttemplate.ExecError:
+    te.Name = e.Name
+  }
+
+  if m := execErrorRe.FindStringSubmatch(err.Error()); m != nil {
+    te.Name = m[1]
+    te.Line, _ = strconv.Atoi(m[2])
+    te.Column, _ = strconv.Atoi(m[3])
+    te.Action = m[4]
+    te.Message = m[5]
+  } else if m := parseErrorRe.FindStringSubmatch(err.Error()); m != nil {
+    te.Name = m[1]
+    te.Line, _ = strconv.Atoi(m[2])
+    te.Message = m[3]
+    var parseErr *parseError
+    if errors.As(err, &parseErr) {
+      te.Column, te.Action = parseErr.locate(te.Line, te.Message)
+    }
+  }
+
+  var limitErr *LimitError
+  if errors.As(err, &limitErr) {
+    te.Limit = limitErr.Limit
+    te.Message = limitErr.Error()
+  }
+
+  if strings.HasPrefix(te.Action, "_") {
+    // The action was inserted by instrument or instrumentSources; its
+    // position is that of the action it stands next to.
+    te.Action = ""
+  }
+  te.Action = stripMarkers(te.Action)
+  te.Message = stripMarkers(te.Message)
+  return te
+}
+
+// stripMarkers removes from s, the text of an error or action, the calls
+// to the functions instrument and instrumentSources insert into templates,
+// so that errors read as if the template had not been instrumented.
+func stripMarkers(s string) string {
+  s = markerCallRe.ReplaceAllString(s, "")
+  s = markerActionRe.ReplaceAllString(s, "")
+  s = markerCommandRe.ReplaceAllString(s, "")
+  for {
+    loc := markerContextRe.FindStringIndex(s)
+    if loc == nil {
+      return s
+    }
+    end := closingParen(s, loc[0])
+    if end < 0 {
+      return s
+    }
+    s = s[:loc[0]] + s[loc[1]:end] + s[end+1:]
+  }
+}
+
+// closingParen returns the index of the parenthesis closing the one at
+// start in s, skipping quoted strings, or -1 if there is none.
+func closingParen(s string, start int) int {
+  depth := 0
+  for i := start; i < len(s); i++ {
+    switch s[i] {
+    case '(':
+      depth++
+    case ')':
+      if depth--; depth == 0 {
+        return i
+      }
+    case '"', '`', '\'':
+      quote := s[i]
+      for i++; i < len(s) && s[i] != quote; i++ {
+        if s[i] == '\\' && quote != '`' {
+          i++
+        }
+      }
+    }
+  }
+  return -1
+}
+
+// parseError is a parse error along with the source and delimiters of the
+// template that failed to parse.
+type parseError struct {
+  err         error
+  source      string
+  left, right string
+}
+
+func (e *parseError) Error() string {
+  return e.err.Error()
+}
+
+func (e *parseError) Unwrap() error {
+  return e.err
+}
+
+// locate returns the column of the token named in the message of a parse
+// error at line, and the action it is part of. The column is 0 and the
+// action empty if the message names no token or none of the actions
+// crossing line holds it.
+func (e *parseError) locate(line int, message string) (int, string) {
+  m := tokenRe.FindStringSubmatch(message)
+  if m == nil {
+    return 0, ""
+  }
+  token := m[2]
+  if m[1] != "" {
+    var err error
+    if token, err = strconv.Unquote(`"` + m[1] + `"`); err != nil {
+      token = m[1]
+    }
+  }
+  if token == "" {
+    return 0, ""
+  }
+
+  lineStart := 0
+  for i := 1; i < line; i++ {
+    n := strings.IndexByte(e.source[lineStart:], '\n')
+    if n < 0 {
+      return 0, ""
+    }
+    lineStart += n + 1
+  }
+  lineEnd := len(e.source)
+  if n := strings.IndexByte(e.source[lineStart:], '\n'); n >= 0 {
+    lineEnd = lineStart + n
+  }
+
+  left, right := e.left, e.right
+  if left == "" {
+    left = "{{"
+  }
+  if right == "" {
+    right = "}}"
+  }
+  for start := 0; start < lineEnd; {
+    i := strings.Index(e.source[start:], left)
+    if i < 0 {
+      break
+    }
+    actionStart := start + i
+    bodyStart := actionStart + len(left)
+    bodyEnd, actionEnd := len(e.source), len(e.source)
+    if j := strings.Index(e.source[bodyStart:], right); j >= 0 {
+      bodyEnd = bodyStart + j
+      actionEnd = bodyEnd + len(right)
+    }
+    start = actionEnd
+
+    // Only the part of the action's body on line can hold the token.
+    from, to := bodyStart, bodyEnd
+    if from < lineStart {
+      from = lineStart
+    }
+    if to > lineEnd {
+      to = lineEnd
+    }
+    if from >= to {
+      continue
+    }
+    if k := strings.Index(e.source[from:to], token); k >= 0 {
+      return from + k - lineStart, e.source[actionStart:actionEnd]
+    }
+  }
+  return 0, ""
+}
+
+// recoverError turns a panic raised while rendering into an error.
+func recoverError(r interface{}) error {
+  if err, ok := r.(error); ok {
+    return err
+  }
+  return fmt.Errorf("%v", r)
+}
@@ -0,0 +1,104 @@
+package render
+
+import (
+  "context"
+  "io"
+  "strings"
+  "testing"
+)
+
+func TestParseErrorLocation(t *testing.T) {
+  tests := []struct {
+    tmpl   string
+    line   int
+    column int
+    action string
+  }{
+    {"{{ foo }}", 1, 3, "{{ foo }}"},
+    {"a\n{{ .A }} {{ .B } }}", 2, 15, "{{ .B } }}"},
+    {"{{ .A }}\n  {{ .B | bar }}", 2, 10, "{{ .B | bar }}"},
+    {"x\n  {{ if .A }}", 2, 0, ""},
+  }
+
+  for _, test := range tests {
+    result, err := Render(nil, test.tmpl, Options{})
+    if err == nil {
+      t.Errorf("%q: no error", test.tmpl)
+      continue
+    }
+    e := result.Errors[0]
+    if e.Line != test.line || e.Column != test.column || e.Action != test.action {
+      t.Errorf("%q: got %d:%d %q, want %d:%d %q", test.tmpl, e.Line, e.Column, e.Action, test.line, test.column, test.action)
+    }
+  }
+}
+
+func TestParseErrorLocationDelims(t *testing.T) {
+  result, _ := Render(nil, "[[ .A ]] [[ foo ]]", Options{LeftDelim: "[[", RightDelim: "]]"})
+  if len(result.Errors) != 1 {
+    t.Fatalf("got %d errors, want 1", len(result.Errors))
+  }
+  if e := result.Errors[0]; e.Column != 12 || e.Action != "[[ foo ]]" {
+    t.Errorf("got %d %q, want 12 %q", e.Column, e.Action, "[[ foo ]]")
+  }
+}
+
+func TestErrorsHideMarkers(t *testing.T) {
+  env := NewEnvironment(nil)
+  env.RegisterPartial("p.html", `{{ .a }}{{ index .list 5 }}`)
+  data := map[string]interface{}{"n": 3, "list": []interface{}{1}}
+  canceled, cancel := context.WithCancel(context.Background())
+  cancel()
+
+  tests := []struct {
+    name string
+    tmpl string
+    opts Options
+    ctx  context.Context
+  }{
+    {"canceled", `{{ .n }}`, Options{}, canceled},
+    {"canceled template call", `{{ define "t" }}x{{ end }}{{ template "t" . }}`, Options{}, canceled},
+    {"step limit", `{{ .n }}{{ .n }}`, Options{Limits: Limits{MaxSteps: 1}}, nil},
+    {"missing partial", `{{ partial "nope.html" . }}`, Options{SourceMap: true}, nil},
+    {"failing partial", `{{ partial "p.html" (dict "a" 1 "list" .list) }}`, Options{SourceMap: true}, nil},
+    {"range", `{{ range $i, $v := .n }}{{ end }}`, Options{SourceMap: true}, nil},
+    {"with variable", `{{ with $x := index .list 5 }}{{ end }}`, Options{SourceMap: true}, nil},
+  }
+
+  for _, test := range tests {
+    ctx := test.ctx
+    if ctx == nil {
+      ctx = context.Background()
+    }
+    result, _ := env.Prepare(test.tmpl, test.opts).RenderTo(ctx, io.Discard, data)
+    if len(result.Errors) == 0 {
+      t.Errorf("%s: no error", test.name)
+      continue
+    }
+    for _, e := range result.Errors {
+      for _, text := range []string{e.Action, e.Message, e.Error()} {
+        if strings.Contains(text, "_step") || strings.Contains(text, "_call") || strings.Contains(text, "_src") {
+          t.Errorf("%s: %q names an inserted function", test.name, text)
+        }
+      }
+    }
+  }
+}
+
+func TestStripMarkers(t *testing.T) {
+  tests := []struct {
+    in, want string
+  }{
+    {`template: x:1:3: executing "x" at <_step>: error calling _step: context canceled`, `template: x:1:3: executing "x": context canceled`},
+    {`partial "a.html" (_src "ctx" 3 .)`, `partial "a.html" .`},
+    {`partial "a.html" (_src "ctx" 3 (dict "s" ")" "t" .t))`, `partial "a.html" (dict "s" ")" "t" .t)`},
+    {`.items | _src "range" 12`, `.items`},
+    {`index .list 5`, `index .list 5`},
+  }
+
+  for _, test := range tests {
+    if got := stripMarkers(test.in); got != test.want {
+      t.Errorf("%q: got %q, want %q", test.in, got, test.want)
+    }
+  }
+}
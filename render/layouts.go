@@ -0,0 +1,75 @@
+package render
+
+import (
+  "fmt"
+
+  "github.com/erquhart/netlify-cms-template-parser-go/hugo/output"
+)
+
+// RegisterLayout adds a file from a Hugo layouts tree, such as
+// "layouts/_default/single.html" or "themes/hyde/layouts/partials/head.html".
+// Partials become available to the partial function; everything else is a
+// candidate for ResolveLayout.
+func (env *Environment) RegisterLayout(name, source string) {
+  if isPartial(layoutName(name)) {
+    env.partials.register(name, source)
+  } else {
+    env.layouts.register(name, source)
+  }
+}
+
+// RemoveLayout removes a file previously added with RegisterLayout.
+func (env *Environment) RemoveLayout(name string) {
+  if isPartial(layoutName(name)) {
+    env.partials.remove(name)
+  } else {
+    env.layouts.remove(name)
+  }
+}
+
+// ResolveLayout returns the names of the layout and, if there is one, the
+// base template Hugo would render an entry described by d with in the named
+// output format.
+func (env *Environment) ResolveLayout(d output.LayoutDescriptor, format string) (layout, base string, err error) {
+  f, err := outputFormat(format)
+  if err != nil {
+    return "", "", err
+  }
+
+  exists := func(name string) bool {
+    _, found := env.layouts.get(name)
+    return found
+  }
+
+  layout, found := output.Resolve(output.Layouts(d, f), exists)
+  if !found {
+    return "", "", fmt.Errorf("no %s layout found for kind %q, type %q, section %q and layout %q", f.Name, d.Kind, d.Type, d.Section, d.Layout)
+  }
+
+  base, _ = output.Resolve(output.BaseLayouts(layout), exists)
+  return layout, base, nil
+}
+
+// PrepareEntry prepares the layout Hugo would choose for d, along with its
+// base template.
+func (env *Environment) PrepareEntry(d output.LayoutDescriptor, opts Options) *Prepared {
+  layout, base, err := env.ResolveLayout(d, opts.Format)
+  if err != nil {
+    return &Prepared{err: err}
+  }
+
+  tmpl, _ := env.layouts.get(layout)
+  opts.Name = layout
+  opts.Base, opts.BaseName = "", ""
+  if base != "" {
+    opts.Base, _ = env.layouts.get(base)
+    opts.BaseName = base
+  }
+
+  return env.Prepare(tmpl, opts)
+}
+
+// RenderEntry renders data through the layout Hugo would choose for d.
+func (env *Environment) RenderEntry(data interface{}, d output.LayoutDescriptor, opts Options) (Result, error) {
+  return env.PrepareEntry(d, opts).Render(data)
+}
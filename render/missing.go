@@ -0,0 +1,333 @@
+package render
+
+import (
+  "reflect"
+  "strings"
+  texttemplate "text/template"
+  "text/template/parse"
+)
+
+const (
+  // maxMissingKeyVisits bounds the number of nodes visited while looking for
+  // missing keys, as ranges are walked once per element. Past it, the keys
+  // found so far are reported as truncated.
+  maxMissingKeyVisits = 10000
+
+  // maxMissingKeyDepth bounds how deep {{ template }} and partial calls are
+  // followed.
+  maxMissingKeyDepth = 32
+)
+
+// MissingKey is a field referenced by a template that is absent from the
+// data it was rendered against.
+type MissingKey struct {
+  // Key is the field chain up to the first missing key, e.g. ".author.name"
+  // or "$post.title".
+  Key    string
+  Name   string
+  Line   int
+  Column int
+}
+
+// missingKeyFinder walks parse trees the way text/template would execute
+// them against data, recording field chains that name absent map keys.
+// Partials called with a literal name are walked with their context. Values
+// it cannot work out, such as function results, are not followed.
+type missingKeyFinder struct {
+  set       *templateSet
+  partials  map[string]*partialTemplate
+  tree      *parse.Tree
+  visits    int
+  truncated bool
+  depth     int
+  seen      map[string]bool
+  keys      []*MissingKey
+}
+
+// unknown marks a value the finder could not evaluate.
+type unknown struct{}
+
+// findMissingKeys returns the keys the named template of set, and the
+// partials it calls, refer to that are missing from data. truncated reports
+// whether the search gave up before walking every node, in which case more
+// keys may be missing.
+func findMissingKeys(set *templateSet, partials map[string]*partialTemplate, name string, data interface{}) (keys []*MissingKey, truncated bool) {
+  tree := set.tree(name)
+  if tree == nil || tree.Root == nil {
+    return nil, false
+  }
+
+  f := &missingKeyFinder{set: set, partials: partials, tree: tree, seen: make(map[string]bool)}
+  f.walk(tree.Root, data, map[string]interface{}{"$": data})
+  return f.keys, f.truncated
+}
+
+func (f *missingKeyFinder) walk(node parse.Node, dot interface{}, vars map[string]interface{}) {
+  if f.visits >= maxMissingKeyVisits {
+    f.truncated = true
+    return
+  }
+  f.visits++
+
+  switch n := node.(type) {
+  case *parse.ListNode:
+    if n == nil {
+      return
+    }
+    for _, child := range n.Nodes {
+      f.walk(child, dot, vars)
+    }
+  case *parse.ActionNode:
+    f.pipe(n.Pipe, dot, vars)
+  case *parse.IfNode:
+    f.branch(&n.BranchNode, dot, vars, false)
+  case *parse.WithNode:
+    f.branch(&n.BranchNode, dot, vars, true)
+  case *parse.RangeNode:
+    f.rangeNode(n, dot, vars)
+  case *parse.TemplateNode:
+    f.templateNode(n, dot, vars)
+  }
+}
+
+// branch walks an if or with node, following only the branch that would be
+// taken when the condition can be evaluated.
+func (f *missingKeyFinder) branch(n *parse.BranchNode, dot interface{}, vars map[string]interface{}, with bool) {
+  vars = copyVars(vars)
+  v := f.pipe(n.Pipe, dot, vars)
+  if _, ok := v.(unknown); ok {
+    f.walk(n.ElseList, dot, vars)
+    if !with {
+      f.walk(n.List, dot, vars)
+    }
+    return
+  }
+
+  if truth, ok := texttemplate.IsTrue(v); !ok || !truth {
+    f.walk(n.ElseList, dot, vars)
+    return
+  }
+  if with {
+    dot = v
+  }
+  f.walk(n.List, dot, vars)
+}
+
+func (f *missingKeyFinder) rangeNode(n *parse.RangeNode, dot interface{}, vars map[string]interface{}) {
+  vars = copyVars(vars)
+  v := f.pipe(n.Pipe, dot, vars)
+  if _, ok := v.(unknown); ok {
+    return
+  }
+
+  rv := reflect.ValueOf(v)
+  switch rv.Kind() {
+  case reflect.Array, reflect.Slice:
+    if rv.Len() == 0 {
+      break
+    }
+    for i := 0; i < rv.Len(); i++ {
+      f.rangeVars(n.Pipe, vars, i, rv.Index(i).Interface())
+      f.walk(n.List, rv.Index(i).Interface(), vars)
+    }
+    return
+  case reflect.Map:
+    if rv.Len() == 0 {
+      break
+    }
+    iter := rv.MapRange()
+    for iter.Next() {
+      f.rangeVars(n.Pipe, vars, iter.Key().Interface(), iter.Value().Interface())
+      f.walk(n.List, iter.Value().Interface(), vars)
+    }
+    return
+  }
+
+  f.walk(n.ElseList, dot, vars)
+}
+
+// rangeVars binds the variables declared by a range pipeline.
+func (f *missingKeyFinder) rangeVars(pipe *parse.PipeNode, vars map[string]interface{}, key, elem interface{}) {
+  switch len(pipe.Decl) {
+  case 1:
+    vars[pipe.Decl[0].Ident[0]] = elem
+  case 2:
+    vars[pipe.Decl[0].Ident[0]] = key
+    vars[pipe.Decl[1].Ident[0]] = elem
+  }
+}
+
+func (f *missingKeyFinder) templateNode(n *parse.TemplateNode, dot interface{}, vars map[string]interface{}) {
+  var v interface{}
+  if n.Pipe != nil {
+    v = f.pipe(n.Pipe, dot, vars)
+    if _, ok := v.(unknown); ok {
+      return
+    }
+  }
+
+  f.call(f.set, n.Name, v)
+}
+
+// partial walks the partial Include would execute for name, the way
+// text/template would execute it with context as dot.
+func (f *missingKeyFinder) partial(name string, context interface{}) {
+  name = strings.TrimPrefix(name, "partials/")
+  for _, n := range []string{"partials/" + name, "theme/partials/" + name} {
+    pt, found := f.partials[n]
+    if !found {
+      n += ".html"
+      pt, found = f.partials[n]
+    }
+    if found {
+      if pt.err == nil {
+        f.call(pt.set, n, context)
+      }
+      return
+    }
+  }
+}
+
+// call walks the named template of set with dot, up to maxMissingKeyDepth
+// calls deep.
+func (f *missingKeyFinder) call(set *templateSet, name string, dot interface{}) {
+  tree := set.tree(name)
+  if tree == nil || tree.Root == nil || f.depth >= maxMissingKeyDepth {
+    return
+  }
+
+  outerSet, outerTree := f.set, f.tree
+  f.set, f.tree = set, tree
+  f.depth++
+  f.walk(tree.Root, dot, map[string]interface{}{"$": dot})
+  f.depth--
+  f.set, f.tree = outerSet, outerTree
+}
+
+// pipe checks every field referenced by the pipeline, walks the partials it
+// calls and returns its value, or unknown if it is anything but a single
+// field or variable.
+func (f *missingKeyFinder) pipe(pipe *parse.PipeNode, dot interface{}, vars map[string]interface{}) interface{} {
+  if pipe == nil {
+    return unknown{}
+  }
+
+  var v interface{} = unknown{}
+  cmds := unmarked(pipe.Cmds)
+  for _, cmd := range cmds {
+    args := make([]interface{}, len(cmd.Args))
+    for i, arg := range cmd.Args {
+      args[i] = f.arg(arg, dot, vars)
+      v = args[i]
+    }
+    f.partialCall(cmd, args)
+    if len(cmds) > 1 || len(cmd.Args) > 1 {
+      v = unknown{}
+    }
+  }
+
+  for _, decl := range pipe.Decl {
+    vars[decl.Ident[0]] = v
+  }
+  return v
+}
+
+// partialCall walks the partial called by cmd, whose arguments evaluate to
+// args, if it is a call to partial or partialCached with a literal name and
+// a context the finder could work out.
+func (f *missingKeyFinder) partialCall(cmd *parse.CommandNode, args []interface{}) {
+  if len(cmd.Args) < 2 {
+    return
+  }
+  ident, ok := cmd.Args[0].(*parse.IdentifierNode)
+  if !ok || ident.Ident != "partial" && ident.Ident != "partialCached" {
+    return
+  }
+  name, ok := cmd.Args[1].(*parse.StringNode)
+  if !ok {
+    return
+  }
+
+  var context interface{}
+  if len(args) > 2 {
+    context = args[2]
+  }
+  if _, ok := context.(unknown); ok {
+    return
+  }
+  f.partial(name.Text, context)
+}
+
+func (f *missingKeyFinder) arg(node parse.Node, dot interface{}, vars map[string]interface{}) interface{} {
+  switch n := node.(type) {
+  case *parse.DotNode:
+    return dot
+  case *parse.FieldNode:
+    return f.fields(n, dot, ".", n.Ident)
+  case *parse.VariableNode:
+    v, found := vars[n.Ident[0]]
+    if !found {
+      return unknown{}
+    }
+    return f.fields(n, v, n.Ident[0]+".", n.Ident[1:])
+  case *parse.PipeNode:
+    f.pipe(n, dot, copyVars(vars))
+  case *parse.ChainNode:
+    f.arg(n.Node, dot, vars)
+  }
+  return unknown{}
+}
+
+// fields follows the chain of map keys idents from v, recording the chain up
+// to the first key that is missing.
+func (f *missingKeyFinder) fields(node parse.Node, v interface{}, prefix string, idents []string) interface{} {
+  for i, ident := range idents {
+    if _, ok := v.(unknown); ok {
+      return v
+    }
+
+    rv, isNil := indirect(reflect.ValueOf(v))
+    if isNil || rv.Kind() != reflect.Map || rv.Type().Key().Kind() != reflect.String {
+      return unknown{}
+    }
+
+    value := rv.MapIndex(reflect.ValueOf(ident).Convert(rv.Type().Key()))
+    if !value.IsValid() {
+      f.record(node, prefix+strings.Join(idents[:i+1], "."))
+      return unknown{}
+    }
+    v = value.Interface()
+  }
+  return v
+}
+
+func (f *missingKeyFinder) record(node parse.Node, key string) {
+  location, _ := f.tree.ErrorContext(node)
+  if f.seen[location+key] {
+    return
+  }
+  f.seen[location+key] = true
+
+  k := &MissingKey{Key: key, Name: f.tree.ParseName}
+  k.Line, k.Column = nodeLocation(f.tree, node)
+  f.keys = append(f.keys, k)
+}
+
+func copyVars(vars map[string]interface{}) map[string]interface{} {
+  c := make(map[string]interface{}, len(vars))
+  for k, v := range vars {
+    c[k] = v
+  }
+  return c
+}
+
+// indirect dereferences pointers and interfaces, reporting whether it ran
+// into a nil or invalid value.
+func indirect(v reflect.Value) (rv reflect.Value, isNil bool) {
+  for ; v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface; v = v.Elem() {
+    if v.IsNil() {
+      return v, true
+    }
+  }
+  return v, !v.IsValid()
+}
@@ -0,0 +1,70 @@
+package render
+
+import (
+  "reflect"
+  "strings"
+  "testing"
+)
+
+func TestMissingKeys(t *testing.T) {
+  env := NewEnvironment(nil)
+  env.RegisterPartial("p.html", `{{ .nope }}`)
+  env.RegisterPartial("author.html", `{{ .name }}{{ .bio }}`)
+  env.RegisterPartial("nested.html", `{{ partial "p.html" .post }}`)
+
+  data := map[string]interface{}{
+    "title":   "T",
+    "post":    map[string]interface{}{"title": "P"},
+    "authors": []interface{}{map[string]interface{}{"name": "Jo"}},
+  }
+
+  tests := []struct {
+    name string
+    tmpl string
+    want []string
+  }{
+    {"none", `{{ .title }}{{ .post.title }}`, nil},
+    {"field", `{{ .zz }}`, []string{"preview .zz"}},
+    {"chain", `{{ .post.zz.name }}`, []string{"preview .post.zz"}},
+    {"variable", `{{ $p := .post }}{{ $p.zz }}`, []string{"preview $p.zz"}},
+    {"range", `{{ range .authors }}{{ .name }}{{ .bio }}{{ end }}`, []string{"preview .bio"}},
+    {"partial", `{{ partial "p.html" . }}{{ $.zz }}`, []string{"partials/p.html .nope", "preview $.zz"}},
+    {"partial context", `{{ range .authors }}{{ partial "author.html" . }}{{ end }}`, []string{"partials/author.html .bio"}},
+    {"partialCached", `{{ partialCached "partials/p.html" .post "v" }}`, []string{"partials/p.html .nope"}},
+    {"partial in partial", `{{ partial "nested.html" . }}`, []string{"partials/p.html .nope"}},
+    {"partial result", `{{ $s := partial "p.html" . }}{{ $s }}`, []string{"partials/p.html .nope"}},
+    {"unknown context", `{{ partial "author.html" (index .authors 0) }}`, nil},
+  }
+
+  for _, test := range tests {
+    result, _ := env.Render(data, test.tmpl, Options{})
+    var got []string
+    for _, k := range result.MissingKeys {
+      got = append(got, k.Name+" "+k.Key)
+    }
+    if !reflect.DeepEqual(got, test.want) {
+      t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+    }
+    if result.MissingKeysTruncated {
+      t.Errorf("%s: truncated", test.name)
+    }
+  }
+}
+
+func TestMissingKeysTruncated(t *testing.T) {
+  items := make([]interface{}, maxMissingKeyVisits)
+  for i := range items {
+    items[i] = map[string]interface{}{}
+  }
+
+  env := NewEnvironment(nil)
+  result, _ := env.Render(map[string]interface{}{"items": items}, `{{ range .items }}{{ .a }}{{ end }}{{ .zz }}`, Options{})
+  if !result.MissingKeysTruncated {
+    t.Error("not truncated")
+  }
+  for _, k := range result.MissingKeys {
+    if strings.Contains(k.Key, "zz") {
+      t.Errorf("found %s past the limit", k.Key)
+    }
+  }
+}
@@ -0,0 +1,390 @@
+package render
+
+import (
+  "bytes"
+  "strconv"
+  "strings"
+  "sync"
+
+  "golang.org/x/net/html"
+  "golang.org/x/net/html/atom"
+)
+
+// maxDiffCells bounds the size of the table used to align two lists of
+// sibling nodes. Longer lists are aligned by position instead.
+const maxDiffCells = 1 << 18
+
+// Patch is a single change to apply to the DOM rendered from the previous
+// output to bring it up to date.
+type Patch struct {
+  // Op is one of "insert", "remove", "replace", "replaceText",
+  // "setAttribute" or "removeAttribute".
+  Op string
+
+  // Path is the position of the node as the indexes of each of its
+  // ancestors among the childNodes of their parents, starting from the
+  // container of the output, or from the document if the output is a whole
+  // page. It refers to the DOM as left by the patches before it. An insert
+  // puts its node at Path, shifting the node there and its later siblings.
+  Path []int
+
+  // HTML is the markup of the node to insert or to replace the node at Path
+  // with.
+  HTML string
+
+  // Text is the new content of the text or comment node at Path.
+  Text string
+
+  // Name and Value are the attribute to set or remove.
+  Name  string
+  Value string
+}
+
+// Patcher renders a prepared template and describes how its output differs
+// from that of the previous render, so that a preview can be updated without
+// replacing all of it.
+type Patcher struct {
+  mu       sync.Mutex
+  prepared *Prepared
+  rendered bool
+  output   string
+  root     *html.Node
+}
+
+// NewPatcher returns a Patcher rendering p.
+func NewPatcher(p *Prepared) *Patcher {
+  return &Patcher{prepared: p}
+}
+
+// Reset forgets the previous output, so that the next render replaces all
+// of it.
+func (pt *Patcher) Reset() {
+  pt.mu.Lock()
+  defer pt.mu.Unlock()
+  pt.rendered = false
+  pt.output = ""
+  pt.root = nil
+}
+
+// Render executes the template against data and sets the patches of the
+// result. The first render, and any whose output cannot be diffed against
+// the previous one, replaces the whole output with a patch at the empty
+// path. A render that fails has no patches, so that the preview keeps the
+// last good output, which the next render is diffed against.
+func (pt *Patcher) Render(data interface{}) (Result, error) {
+  return pt.render(data).values()
+}
+
+func (pt *Patcher) render(data interface{}) *Result {
+  pt.mu.Lock()
+  defer pt.mu.Unlock()
+
+  result := pt.prepared.render(data)
+  result.Patches = []*Patch{}
+  if len(result.Errors) > 0 || pt.rendered && result.HTML == pt.output {
+    return result
+  }
+
+  if pt.prepared.set.text != nil {
+    // Plain text output is shown as a single text node.
+    result.Patches = append(result.Patches, &Patch{Op: "replaceText", Path: []int{}, Text: result.HTML})
+    pt.rendered, pt.output = true, result.HTML
+    return result
+  }
+
+  root, err := parseOutput(result.HTML)
+  if err != nil {
+    result.addError(err)
+    return result
+  }
+
+  if !pt.rendered || pt.root == nil || pt.root.Type != root.Type {
+    result.Patches = append(result.Patches, &Patch{Op: "replace", Path: []int{}, HTML: result.HTML})
+  } else {
+    d := &differ{}
+    d.node(nil, pt.root, root)
+    result.Patches = d.patches
+  }
+  pt.rendered, pt.output, pt.root = true, result.HTML, root
+  return result
+}
+
+// parseOutput parses s as a whole document if it starts with a doctype or
+// <html> tag, or as the content of a <body> otherwise.
+func parseOutput(s string) (*html.Node, error) {
+  start := strings.ToLower(strings.TrimSpace(s))
+  if strings.HasPrefix(start, "<!doctype") || strings.HasPrefix(start, "<html") {
+    return html.Parse(strings.NewReader(s))
+  }
+
+  body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+  nodes, err := html.ParseFragment(strings.NewReader(s), body)
+  if err != nil {
+    return nil, err
+  }
+  root := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+  for _, n := range nodes {
+    root.AppendChild(n)
+  }
+  return root, nil
+}
+
+// differ collects the patches turning one tree into another.
+type differ struct {
+  patches []*Patch
+  keys    map[*html.Node]string
+}
+
+func (d *differ) add(p *Patch) {
+  d.patches = append(d.patches, p)
+}
+
+// key identifies n and its descendants: nodes with the same key render the
+// same. Keys are built from the keys of the children and kept for the rest of
+// the diff, so keying a tree takes time proportional to its size however
+// deep the diff recurses.
+func (d *differ) key(n *html.Node) string {
+  if k, ok := d.keys[n]; ok {
+    return k
+  }
+
+  parts := []string{strconv.Itoa(int(n.Type)), n.Namespace, n.Data}
+  for _, attr := range n.Attr {
+    parts = append(parts, attr.Namespace, attr.Key, attr.Val)
+  }
+  for c := n.FirstChild; c != nil; c = c.NextSibling {
+    parts = append(parts, d.key(c))
+  }
+  k := cacheKey(parts...)
+
+  if d.keys == nil {
+    d.keys = make(map[*html.Node]string)
+  }
+  d.keys[n] = k
+  return k
+}
+
+// node diffs a and b, which have the same signature.
+func (d *differ) node(path []int, a, b *html.Node) {
+  switch a.Type {
+  case html.TextNode, html.CommentNode:
+    if a.Data != b.Data {
+      d.add(&Patch{Op: "replaceText", Path: path, Text: b.Data})
+    }
+  case html.DoctypeNode:
+    if d.key(a) != d.key(b) {
+      d.add(&Patch{Op: "replace", Path: path, HTML: renderNode(b)})
+    }
+  case html.ElementNode:
+    d.attrs(path, a, b)
+    d.children(path, a, b)
+  case html.DocumentNode:
+    d.children(path, a, b)
+  }
+}
+
+func (d *differ) attrs(path []int, a, b *html.Node) {
+  old := make(map[string]string, len(a.Attr))
+  for _, attr := range a.Attr {
+    old[attrName(attr)] = attr.Val
+  }
+
+  for _, attr := range b.Attr {
+    name := attrName(attr)
+    if val, found := old[name]; !found || val != attr.Val {
+      d.add(&Patch{Op: "setAttribute", Path: path, Name: name, Value: attr.Val})
+    }
+    delete(old, name)
+  }
+  for _, attr := range a.Attr {
+    if _, found := old[attrName(attr)]; found {
+      d.add(&Patch{Op: "removeAttribute", Path: path, Name: attrName(attr)})
+    }
+  }
+}
+
+func attrName(attr html.Attribute) string {
+  if attr.Namespace != "" {
+    return attr.Namespace + ":" + attr.Key
+  }
+  return attr.Key
+}
+
+// children diffs the children of a and b. Each patch is applied before the
+// next, so the index of a node is its position among the children as they
+// are at that point: the ones before it are already up to date.
+func (d *differ) children(path []int, a, b *html.Node) {
+  edits := d.align(childNodes(a), childNodes(b))
+  i := 0
+  for k := 0; k < len(edits); k++ {
+    e := edits[k]
+    switch e.op {
+    case editKeep:
+      if !e.equal {
+        d.node(childPath(path, i), e.a, e.b)
+      }
+      i++
+    case editRemove:
+      if k+1 < len(edits) && edits[k+1].op == editInsert {
+        d.add(&Patch{Op: "replace", Path: childPath(path, i), HTML: renderNode(edits[k+1].b)})
+        k++
+        i++
+      } else {
+        d.add(&Patch{Op: "remove", Path: childPath(path, i)})
+      }
+    case editInsert:
+      d.add(&Patch{Op: "insert", Path: childPath(path, i), HTML: renderNode(e.b)})
+      i++
+    }
+  }
+}
+
+func childNodes(n *html.Node) []*html.Node {
+  var nodes []*html.Node
+  for c := n.FirstChild; c != nil; c = c.NextSibling {
+    nodes = append(nodes, c)
+  }
+  return nodes
+}
+
+func childPath(path []int, i int) []int {
+  c := make([]int, len(path), len(path)+1)
+  copy(c, path)
+  return append(c, i)
+}
+
+const (
+  editKeep = iota
+  editRemove
+  editInsert
+)
+
+// edit is a step of the alignment of two lists of siblings: a kept node,
+// diffed against its counterpart, a removed node or an inserted one.
+type edit struct {
+  op    int
+  a, b  *html.Node
+  equal bool
+}
+
+// align returns the edits turning the siblings a into b, keeping as many
+// identical nodes as possible and then as many nodes of the same type.
+func (d *differ) align(a, b []*html.Node) []edit {
+  ka, kb := d.nodeKeys(a), d.nodeKeys(b)
+
+  var head, tail []edit
+  for len(a) > 0 && len(b) > 0 && ka[0] == kb[0] {
+    head = append(head, edit{op: editKeep, a: a[0], b: b[0], equal: true})
+    a, b, ka, kb = a[1:], b[1:], ka[1:], kb[1:]
+  }
+  for len(a) > 0 && len(b) > 0 && ka[len(ka)-1] == kb[len(kb)-1] {
+    tail = append([]edit{{op: editKeep, a: a[len(a)-1], b: b[len(b)-1], equal: true}}, tail...)
+    a, b, ka, kb = a[:len(a)-1], b[:len(b)-1], ka[:len(ka)-1], kb[:len(kb)-1]
+  }
+
+  var middle []edit
+  if (len(a)+1)*(len(b)+1) > maxDiffCells {
+    middle = alignByPosition(a, b)
+  } else {
+    middle = alignByWeight(a, b, ka, kb)
+  }
+  return append(append(head, middle...), tail...)
+}
+
+// alignByWeight finds the alignment scoring 2 for each identical pair of
+// nodes kept and 1 for each pair of the same type.
+func alignByWeight(a, b []*html.Node, ka, kb []string) []edit {
+  weight := func(i, j int) int {
+    switch {
+    case ka[i] == kb[j]:
+      return 2
+    case signature(a[i]) == signature(b[j]):
+      return 1
+    }
+    return 0
+  }
+
+  n, m := len(a), len(b)
+  score := make([][]int, n+1)
+  for i := range score {
+    score[i] = make([]int, m+1)
+  }
+  for i := n - 1; i >= 0; i-- {
+    for j := m - 1; j >= 0; j-- {
+      best := score[i+1][j]
+      if score[i][j+1] > best {
+        best = score[i][j+1]
+      }
+      if w := weight(i, j); w > 0 && w+score[i+1][j+1] > best {
+        best = w + score[i+1][j+1]
+      }
+      score[i][j] = best
+    }
+  }
+
+  var edits []edit
+  i, j := 0, 0
+  for i < n || j < m {
+    switch {
+    case i < n && j < m && weight(i, j) > 0 && score[i][j] == weight(i, j)+score[i+1][j+1]:
+      edits = append(edits, edit{op: editKeep, a: a[i], b: b[j], equal: ka[i] == kb[j]})
+      i++
+      j++
+    case i < n && (j == m || score[i][j] == score[i+1][j]):
+      edits = append(edits, edit{op: editRemove, a: a[i]})
+      i++
+    default:
+      edits = append(edits, edit{op: editInsert, b: b[j]})
+      j++
+    }
+  }
+  return edits
+}
+
+// alignByPosition pairs the nodes of a and b by index.
+func alignByPosition(a, b []*html.Node) []edit {
+  var edits []edit
+  for i := 0; i < len(a) || i < len(b); i++ {
+    switch {
+    case i >= len(b):
+      edits = append(edits, edit{op: editRemove, a: a[i]})
+    case i >= len(a):
+      edits = append(edits, edit{op: editInsert, b: b[i]})
+    case signature(a[i]) == signature(b[i]):
+      edits = append(edits, edit{op: editKeep, a: a[i], b: b[i]})
+    default:
+      edits = append(edits, edit{op: editRemove, a: a[i]}, edit{op: editInsert, b: b[i]})
+    }
+  }
+  return edits
+}
+
+// signature identifies the type of a node: nodes with the same signature
+// are patched in place rather than replaced.
+func signature(n *html.Node) string {
+  switch n.Type {
+  case html.TextNode:
+    return "#text"
+  case html.CommentNode:
+    return "#comment"
+  case html.DoctypeNode:
+    return "#doctype"
+  case html.ElementNode:
+    return n.Namespace + ":" + n.Data
+  }
+  return ""
+}
+
+func (d *differ) nodeKeys(nodes []*html.Node) []string {
+  s := make([]string, len(nodes))
+  for i, n := range nodes {
+    s[i] = d.key(n)
+  }
+  return s
+}
+
+func renderNode(n *html.Node) string {
+  var buf bytes.Buffer
+  html.Render(&buf, n)
+  return buf.String()
+}
@@ -0,0 +1,237 @@
+package render
+
+import (
+  "fmt"
+  "strings"
+  "testing"
+
+  "golang.org/x/net/html"
+)
+
+func TestPatcherErrors(t *testing.T) {
+  env := NewEnvironment(nil)
+  pt := NewPatcher(env.Prepare(`<p>{{ .title }}</p>{{ if .fail }}{{ index .title 5 }}{{ end }}`, Options{}))
+
+  result, err := pt.Render(map[string]interface{}{"title": "a"})
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(result.Patches) != 1 || result.Patches[0].Op != "replace" {
+    t.Fatalf("first render: got %q, want a single replace", ops(result.Patches))
+  }
+
+  result, err = pt.Render(map[string]interface{}{"title": "b", "fail": true})
+  if err == nil {
+    t.Fatal("failing render: no error")
+  }
+  if len(result.Patches) != 0 {
+    t.Errorf("failing render: got %d patches, want none", len(result.Patches))
+  }
+
+  result, err = pt.Render(map[string]interface{}{"title": "c"})
+  if err != nil {
+    t.Fatal(err)
+  }
+  if got, want := ops(result.Patches), "replaceText [0 0] c"; got != want {
+    t.Errorf("render after failure: got %q, want %q", got, want)
+  }
+}
+
+func TestPatcherParseError(t *testing.T) {
+  env := NewEnvironment(nil)
+  data := map[string]interface{}{"title": "a"}
+
+  pt := NewPatcher(env.Prepare(`<p>{{ .title }}</p>`, Options{}))
+  if _, err := pt.Render(data); err != nil {
+    t.Fatal(err)
+  }
+
+  // The editor swaps in a new template as it is typed, keeping the
+  // patcher's previous output.
+  pt.prepared = env.Prepare(`<p>{{ .title </p>`, Options{})
+  result, err := pt.Render(data)
+  if err == nil {
+    t.Fatal("no parse error")
+  }
+  if len(result.Patches) != 0 {
+    t.Errorf("got %q, want no patches", ops(result.Patches))
+  }
+
+  pt.prepared = env.Prepare(`<p>{{ .title }}!</p>`, Options{})
+  result, err = pt.Render(data)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if got, want := ops(result.Patches), "replaceText [0 0] a!"; got != want {
+    t.Errorf("got %q, want %q", got, want)
+  }
+}
+
+func TestDiff(t *testing.T) {
+  // Lists differing at both ends are too long to align by weight.
+  list := func(from, to int, last string) string {
+    var b strings.Builder
+    b.WriteString("<ul>")
+    for i := from; i < to; i++ {
+      fmt.Fprintf(&b, "<li>%d</li>", i)
+    }
+    b.WriteString(last + "</ul>")
+    return b.String()
+  }
+
+  tests := []struct {
+    name string
+    old  string
+    new  string
+    ops  string
+  }{
+    {"unchanged", `<p>a</p>`, `<p>a</p>`, ""},
+    {"text", `<p>a</p>`, `<p>b</p>`, "replaceText [0 0] b"},
+    {"insert before text", `<p>a</p>`, `<p><b>x</b>a</p>`, "insert [0 0]"},
+    {"insert text before element", `<p><b>x</b></p>`, `<p>a<b>x</b></p>`, "insert [0 0]"},
+    {"append", `<ul><li>1</li></ul>`, `<ul><li>1</li><li>2</li></ul>`, "insert [0 1]"},
+    {"remove", `<ul><li>1</li><li>2</li><li>3</li></ul>`, `<ul><li>1</li><li>3</li></ul>`, "remove [0 1]"},
+    {"remove and insert become replace", `<div><p>a</p></div>`, `<div><h2>a</h2></div>`, "replace [0 0]"},
+    {"set attribute", `<a href="/a">x</a>`, `<a href="/b" title="t">x</a>`, "setAttribute [0] href; setAttribute [0] title"},
+    {"remove attribute", `<a href="/a" class="c">x</a>`, `<a href="/a">x</a>`, "removeAttribute [0] class"},
+    {"several", `<h1>t</h1><ul><li>1</li><li>2</li></ul><p>e</p>`, `<h1>T</h1><ul><li>0</li><li>1</li><li>2</li></ul><p class="x">e</p>`, ""},
+    {"nested", `<div><ul><li>a</li></ul><p>x</p></div>`, `<div><ul><li>a</li><li>b</li></ul>y<p>x</p></div>`, ""},
+    {"by position", list(0, 600, ""), list(1, 601, ""), ""},
+    {"by position shorter", list(0, 600, ""), list(1, 400, "<b>x</b>"), ""},
+    {"by position longer", list(0, 600, ""), list(1, 700, "") + "<p>z</p>", ""},
+    {"deep", strings.Repeat("<div>", 50) + "a", strings.Repeat("<div>", 50) + "b", "replaceText " + fmt.Sprint(make([]int, 51)) + " b"},
+    {"document", `<!DOCTYPE html><html><head><title>a</title></head><body><p>a</p></body></html>`, `<!DOCTYPE html><html><head><title>b</title></head><body><p>a</p><p>b</p></body></html>`, ""},
+  }
+
+  for _, test := range tests {
+    a, err := parseOutput(test.old)
+    if err != nil {
+      t.Fatal(err)
+    }
+    b, err := parseOutput(test.new)
+    if err != nil {
+      t.Fatal(err)
+    }
+    d := &differ{}
+    d.node(nil, a, b)
+
+    if test.ops != "" || test.old == test.new {
+      if got := ops(d.patches); got != test.ops {
+        t.Errorf("%s: got %q, want %q", test.name, got, test.ops)
+      }
+    }
+
+    if err := applyPatches(a, d.patches); err != nil {
+      t.Errorf("%s: %v", test.name, err)
+      continue
+    }
+    if got, want := renderNode(a), renderNode(b); got != want {
+      t.Errorf("%s: patched tree is\n%s\nwant\n%s", test.name, got, want)
+    }
+  }
+}
+
+// ops summarizes patches for comparison, one "op path [name] [text]" per
+// patch, leaving out inserted HTML.
+func ops(patches []*Patch) string {
+  var s []string
+  for _, p := range patches {
+    op := fmt.Sprintf("%s %v", p.Op, p.Path)
+    if p.Name != "" {
+      op += " " + p.Name
+    }
+    if p.Text != "" {
+      op += " " + p.Text
+    }
+    s = append(s, op)
+  }
+  return strings.Join(s, "; ")
+}
+
+// applyPatches applies patches to the tree root as a preview would to its
+// DOM.
+func applyPatches(root *html.Node, patches []*Patch) error {
+  for _, p := range patches {
+    if len(p.Path) == 0 {
+      return fmt.Errorf("%s at the root", p.Op)
+    }
+    parent, err := nodeAt(root, p.Path[:len(p.Path)-1])
+    if err != nil {
+      return err
+    }
+    i := p.Path[len(p.Path)-1]
+    children := childNodes(parent)
+
+    switch p.Op {
+    case "insert":
+      if i > len(children) {
+        return fmt.Errorf("insert at %v: only %d children", p.Path, len(children))
+      }
+      nodes, err := html.ParseFragment(strings.NewReader(p.HTML), parent)
+      if err != nil {
+        return err
+      }
+      for _, n := range nodes {
+        if i < len(children) {
+          parent.InsertBefore(n, children[i])
+        } else {
+          parent.AppendChild(n)
+        }
+      }
+      continue
+    }
+
+    if i >= len(children) {
+      return fmt.Errorf("%s at %v: only %d children", p.Op, p.Path, len(children))
+    }
+    n := children[i]
+    switch p.Op {
+    case "remove":
+      parent.RemoveChild(n)
+    case "replace":
+      nodes, err := html.ParseFragment(strings.NewReader(p.HTML), parent)
+      if err != nil {
+        return err
+      }
+      for _, c := range nodes {
+        parent.InsertBefore(c, n)
+      }
+      parent.RemoveChild(n)
+    case "replaceText":
+      n.Data = p.Text
+    case "setAttribute":
+      found := false
+      for k := range n.Attr {
+        if attrName(n.Attr[k]) == p.Name {
+          n.Attr[k].Val, found = p.Value, true
+        }
+      }
+      if !found {
+        n.Attr = append(n.Attr, html.Attribute{Key: p.Name, Val: p.Value})
+      }
+    case "removeAttribute":
+      attrs := n.Attr[:0]
+      for _, attr := range n.Attr {
+        if attrName(attr) != p.Name {
+          attrs = append(attrs, attr)
+        }
+      }
+      n.Attr = attrs
+    default:
+      return fmt.Errorf("unknown op %q", p.Op)
+    }
+  }
+  return nil
+}
+
+func nodeAt(root *html.Node, path []int) (*html.Node, error) {
+  n := root
+  for depth, i := range path {
+    children := childNodes(n)
+    if i >= len(children) {
+      return nil, fmt.Errorf("no node at %v", path[:depth+1])
+    }
+    n = children[i]
+  }
+  return n, nil
+}
@@ -0,0 +1,213 @@
+package render
+
+import (
+  "fmt"
+  "path"
+  "reflect"
+  "sort"
+  "strings"
+  "sync"
+  "unicode"
+
+  "github.com/erquhart/netlify-cms-template-parser-go/analysis"
+)
+
+// templateRegistry holds the template sources registered with an
+// environment, keyed by their normalized name.
+type templateRegistry struct {
+  mu        sync.RWMutex
+  normalize func(name string) string
+  sources   map[string]string
+  key       string
+}
+
+func newTemplateRegistry(normalize func(name string) string) *templateRegistry {
+  r := &templateRegistry{
+    normalize: normalize,
+    sources:   make(map[string]string),
+  }
+  r.key = r.computeKey()
+  return r
+}
+
+// layoutName normalizes a path in a Hugo site, such as
+// "layouts/posts/single.html" or "themes/hyde/layouts/_default/list.html",
+// to a name relative to the layouts directory. Theme layouts are prefixed
+// with "theme/".
+func layoutName(name string) string {
+  name = strings.TrimPrefix(path.Clean("/"+name), "/")
+  if strings.HasPrefix(name, "themes/") {
+    parts := strings.SplitN(name, "/", 4)
+    if len(parts) == 4 && parts[2] == "layouts" {
+      return "theme/" + parts[3]
+    }
+  }
+  return strings.TrimPrefix(name, "layouts/")
+}
+
+// partialName normalizes the ways a partial can be referred to, such as
+// "header.html", "partials/header.html" or "layouts/partials/header.html",
+// to the name the partial is registered and looked up under.
+func partialName(name string) string {
+  name = layoutName(name)
+  if !isPartial(name) {
+    name = "partials/" + name
+  }
+  return name
+}
+
+// isPartial reports whether the layout name refers to a partial.
+func isPartial(name string) bool {
+  return strings.HasPrefix(name, "partials/") || strings.HasPrefix(name, "theme/partials/")
+}
+
+// register adds or replaces the template with the given name.
+func (r *templateRegistry) register(name, source string) {
+  r.mu.Lock()
+  r.sources[r.normalize(name)] = source
+  r.key = r.computeKey()
+  r.mu.Unlock()
+}
+
+// remove deletes the template with the given name, if any.
+func (r *templateRegistry) remove(name string) {
+  r.mu.Lock()
+  delete(r.sources, r.normalize(name))
+  r.key = r.computeKey()
+  r.mu.Unlock()
+}
+
+// get returns the source of the template with the given, already
+// normalized, name.
+func (r *templateRegistry) get(name string) (string, bool) {
+  r.mu.RLock()
+  source, found := r.sources[name]
+  r.mu.RUnlock()
+  return source, found
+}
+
+// snapshot returns a copy of the registered sources along with a key that
+// changes whenever they do.
+func (r *templateRegistry) snapshot() (map[string]string, string) {
+  r.mu.RLock()
+  defer r.mu.RUnlock()
+
+  sources := make(map[string]string, len(r.sources))
+  for name, source := range r.sources {
+    sources[name] = source
+  }
+  return sources, r.key
+}
+
+func (r *templateRegistry) computeKey() string {
+  names := make([]string, 0, len(r.sources))
+  for name := range r.sources {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+
+  parts := make([]string, 0, 2*len(names))
+  for _, name := range names {
+    parts = append(parts, name, r.sources[name])
+  }
+  return cacheKey(parts...)
+}
+
+// funcRegistry holds template functions registered in addition to the
+// built-in ones.
+type funcRegistry struct {
+  mu       sync.RWMutex
+  builtins map[string]interface{}
+  funcs    map[string]interface{}
+  key      string
+}
+
+func newFuncRegistry(builtins map[string]interface{}) *funcRegistry {
+  r := &funcRegistry{builtins: builtins, funcs: make(map[string]interface{})}
+  r.key = r.computeKey()
+  return r
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// register adds or replaces the function with the given name. fn must return
+// a single value, or a value and an error, as text/template requires.
+//
+// Built-in functions cannot be replaced. That includes the ones text/template
+// defines itself: a function of the same name would silently shadow them in
+// every template of the environment, while Analyze and Lint still assume
+// text/template's meaning.
+func (r *funcRegistry) register(name string, fn interface{}) error {
+  if !isIdentifier(name) {
+    return fmt.Errorf("function name %q is not a valid identifier", name)
+  }
+  if strings.HasPrefix(name, "_") {
+    return fmt.Errorf("function names starting with an underscore, such as %q, are reserved", name)
+  }
+  if _, found := r.builtins[name]; found || analysis.IsBuiltin(name) {
+    return fmt.Errorf("cannot replace built-in function %q", name)
+  }
+  t := reflect.TypeOf(fn)
+  if t == nil || t.Kind() != reflect.Func || reflect.ValueOf(fn).IsNil() {
+    return fmt.Errorf("value for %q is not a function", name)
+  }
+  switch {
+  case t.NumOut() == 1:
+  case t.NumOut() == 2 && t.Out(1) == errorType:
+  case t.NumOut() == 2:
+    return fmt.Errorf("second result of function %q is %s, not error", name, t.Out(1))
+  default:
+    return fmt.Errorf("function %q has %d results, want 1 or 2", name, t.NumOut())
+  }
+
+  r.mu.Lock()
+  r.funcs[name] = fn
+  r.key = r.computeKey()
+  r.mu.Unlock()
+  return nil
+}
+
+// remove deletes the function with the given name, if any.
+func (r *funcRegistry) remove(name string) {
+  r.mu.Lock()
+  delete(r.funcs, name)
+  r.key = r.computeKey()
+  r.mu.Unlock()
+}
+
+// snapshot returns a copy of the registered functions along with a key that
+// changes whenever the set of names does. Templates only need to be parsed
+// again when a name is added or removed.
+func (r *funcRegistry) snapshot() (map[string]interface{}, string) {
+  r.mu.RLock()
+  defer r.mu.RUnlock()
+
+  funcs := make(map[string]interface{}, len(r.funcs))
+  for name, fn := range r.funcs {
+    funcs[name] = fn
+  }
+  return funcs, r.key
+}
+
+func (r *funcRegistry) computeKey() string {
+  names := make([]string, 0, len(r.funcs))
+  for name := range r.funcs {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  return cacheKey(names...)
+}
+
+// isIdentifier reports whether name can be used as a function name in a
+// template.
+func isIdentifier(name string) bool {
+  if name == "" {
+    return false
+  }
+  for i, r := range name {
+    if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+      return false
+    }
+  }
+  return true
+}
@@ -0,0 +1,48 @@
+package render
+
+import (
+  "errors"
+  "strings"
+  "testing"
+)
+
+func TestRegisterFunction(t *testing.T) {
+  var nilFunc func() string
+
+  tests := []struct {
+    name string
+    fn   interface{}
+    err  string
+  }{
+    {"shout", strings.ToUpper, ""},
+    {"check", func(s string) (string, error) { return s, errors.New("bad") }, ""},
+    {"join", func(parts ...string) string { return strings.Join(parts, ",") }, ""},
+    {"nothing", nil, "not a function"},
+    {"nilFunc", nilFunc, "not a function"},
+    {"value", 1, "not a function"},
+    {"none", func() {}, "has 0 results"},
+    {"three", func() (int, int, int) { return 1, 2, 3 }, "has 3 results"},
+    {"pair", func() (int, int) { return 1, 2 }, "second result"},
+    {"len", func(string) int { return 0 }, "built-in"},
+    {"printf", func(string) string { return "" }, "built-in"},
+    {"eq", func(a, b int) bool { return a == b }, "built-in"},
+    {"_step", strings.ToUpper, "reserved"},
+    {"9lives", strings.ToUpper, "not a valid identifier"},
+  }
+
+  for _, test := range tests {
+    env := NewEnvironment(nil)
+    err := env.RegisterFunction(test.name, test.fn)
+    switch {
+    case test.err == "" && err != nil:
+      t.Errorf("%s: unexpected error %v", test.name, err)
+    case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+      t.Errorf("%s: got error %v, want one containing %q", test.name, err, test.err)
+    }
+
+    // Rejected functions must not break later renders.
+    if _, err := env.Render(nil, `ok`, Options{}); err != nil {
+      t.Errorf("%s: render after registering: %v", test.name, err)
+    }
+  }
+}
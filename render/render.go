@@ -0,0 +1,465 @@
+// Package render renders Hugo templates against Netlify CMS entry data with
+// the functions, partials and layouts of a preview environment. It is what
+// the GopherJS build exposes to the browser, so that a Go server rendering
+// through it produces the same output.
+package render
+
+import (
+  "bytes"
+  "context"
+  "fmt"
+  "html/template"
+  "io"
+  "strconv"
+  "text/template/parse"
+
+  "github.com/erquhart/netlify-cms-template-parser-go/hugo/helpers"
+  "github.com/erquhart/netlify-cms-template-parser-go/hugo/hugolib"
+  "github.com/erquhart/netlify-cms-template-parser-go/hugo/output"
+  "github.com/erquhart/netlify-cms-template-parser-go/hugo/tpl/collections"
+  "github.com/erquhart/netlify-cms-template-parser-go/hugo/tpl/encoding"
+  "github.com/erquhart/netlify-cms-template-parser-go/hugo/tpl/partials"
+  "github.com/erquhart/netlify-cms-template-parser-go/hugo/tpl/safe"
+  "gopkg.in/russross/blackfriday.v2"
+)
+
+const (
+  // templateName is the default name given to the template passed to
+  // Render. It shows up in error messages.
+  templateName = "preview"
+
+  // baseName is the default name given to the base template, if any.
+  baseName = "baseof.html"
+
+  // scratchKey is the key the scratch of a render is added to the data
+  // under, so that templates can use .Scratch and $.Scratch as in Hugo.
+  scratchKey = "Scratch"
+)
+
+// Options control how a template is parsed and executed.
+type Options struct {
+  // Base is the source of a base template, such as a Hugo baseof.html. When
+  // set, the template passed to Render supplies the {{ define }} blocks
+  // overriding those of the base, and the base is what gets rendered.
+  Base string
+
+  // Name and BaseName are the names the template and its base are parsed
+  // under, as reported in errors. They default to templateName and
+  // baseName.
+  Name     string
+  BaseName string
+
+  // Format is the name of the Hugo output format to render, such as "HTML",
+  // "JSON" or "RSS". Plain text formats are rendered with text/template so
+  // that their output is not escaped as HTML. It defaults to HTML.
+  Format string
+
+  // LeftDelim and RightDelim replace the "{{" and "}}" action delimiters
+  // in the template and its base. Partials keep the default delimiters.
+  LeftDelim  string
+  RightDelim string
+
+  // Limits bound the time, steps, partial depth and output size of each
+  // render.
+  Limits Limits
+
+  // MissingKey controls what happens when a template indexes a map with a
+  // key that is not present: "default" prints "<no value>", "zero" prints
+  // the zero value and "error" stops execution with an error. Either way,
+  // the missing keys are listed in the result.
+  MissingKey string
+
+  // SourceMap adds to the result the span of output printed by each action,
+  // along with the data it printed.
+  SourceMap bool
+
+  // PersistScratch makes the render use the scratch of the environment,
+  // whose values carry over from one render to the next, instead of a fresh
+  // one.
+  PersistScratch bool
+}
+
+// withDefaults fills in the default template names.
+func (opts Options) withDefaults() Options {
+  if opts.Name == "" {
+    opts.Name = templateName
+  }
+  if opts.BaseName == "" {
+    opts.BaseName = baseName
+  }
+  return opts
+}
+
+// Result is the outcome of a render: the output produced so far and any
+// errors raised while parsing or executing the template.
+type Result struct {
+  HTML        string
+  Errors      []*TemplateError
+  MissingKeys []*MissingKey
+  // MissingKeysTruncated reports that the search for missing keys gave up
+  // on a large template, so that MissingKeys may be incomplete.
+  MissingKeysTruncated bool
+  // SourceMap is only set if requested in the options.
+  SourceMap []*SourceSpan
+  // Patches is only set by a Patcher.
+  Patches []*Patch
+  // Scratch holds the values of the render's scratch once it is done.
+  Scratch map[string]interface{}
+}
+
+func (r *Result) addError(err error) {
+  r.Errors = append(r.Errors, NewTemplateError(err))
+}
+
+// values returns the result along with its first error, if any.
+func (r *Result) values() (Result, error) {
+  if len(r.Errors) > 0 {
+    return *r, r.Errors[0]
+  }
+  return *r, nil
+}
+
+// Prepared is a parsed template, along with its base and the partials
+// registered when it was parsed, that can be rendered any number of times. If
+// parsing failed, every render reports the parse error.
+type Prepared struct {
+  env      *Environment
+  set      *templateSet
+  partials map[string]*partialTemplate
+  entry    string
+  sources  *sourceTable
+  limits   Limits
+  persist  bool
+  err      error
+}
+
+// partialTemplate is a registered partial, parsed in a set of its own so
+// that its {{ define }} blocks cannot override those of the templates that
+// include it, and so that a parse error only fails the renders calling it.
+type partialTemplate struct {
+  set *templateSet
+  err error
+}
+
+func renderMarkdown(tmpl string) template.HTML {
+  input := []byte(tmpl)
+  output := blackfriday.Run(input)
+  return template.HTML(output)
+}
+
+// funcMap returns the functions available to templates, with partial and
+// partialCached bound to ns.
+func funcMap(ns *partials.Namespace) template.FuncMap {
+  return template.FuncMap{
+    "dict": collections.Dictionary,
+    "first": collections.First,
+    "jsonify": encoding.Jsonify,
+    "markdownify": renderMarkdown,
+    "newScratch": hugolib.NewScratch,
+    "partial": ns.Include,
+    "partialCached": ns.IncludeCached,
+    "safeJS": safe.JS,
+    "slice": collections.Slice,
+    "urlize": helpers.URLize,
+    "where": collections.Where,
+  }
+}
+
+// Prepare parses tmpl together with its base and the partials and functions
+// registered in env, reusing an earlier parse if none of them has changed
+// since.
+func (env *Environment) Prepare(tmpl string, opts Options) *Prepared {
+  opts = opts.withDefaults()
+
+  f, err := outputFormat(opts.Format)
+  if err != nil {
+    return &Prepared{err: err}
+  }
+
+  switch opts.MissingKey {
+  case "", "default", "invalid", "zero", "error":
+  default:
+    return &Prepared{err: fmt.Errorf("unknown missingkey option %q", opts.MissingKey)}
+  }
+
+  sources, partialsKey := env.partials.snapshot()
+  custom, funcsKey := env.custom.snapshot()
+  key := cacheKey(tmpl, opts.Name, opts.Base, opts.BaseName, f.Name,
+    opts.LeftDelim, opts.RightDelim, opts.MissingKey, strconv.FormatBool(opts.SourceMap),
+    partialsKey, funcsKey)
+  p, found := env.cache.get(key)
+  if !found {
+    p = &Prepared{env: env, entry: opts.Name}
+    p.set, p.err = env.parseTemplates(tmpl, opts, f, custom)
+    if p.err == nil {
+      p.partials = env.parsePartials(opts, f, sources, custom)
+    }
+    if p.err == nil && opts.SourceMap {
+      sets := []*templateSet{p.set}
+      for _, pt := range p.partials {
+        if pt.err == nil {
+          sets = append(sets, pt.set)
+        }
+      }
+      p.sources = instrumentSources(sets...)
+    }
+    if p.err == nil && opts.Base != "" && isEmptyTree(p.set.tree(opts.Name)) {
+      // As in Hugo, a template made up only of {{ define }} blocks renders
+      // through its base.
+      p.entry = opts.BaseName
+    }
+    env.cache.add(key, p)
+  }
+
+  // Limits and the scratch do not affect parsing, so they are not part of
+  // the cache key.
+  prepared := *p
+  prepared.limits = opts.Limits
+  prepared.persist = opts.PersistScratch
+  return &prepared
+}
+
+// parseTemplates parses the base and tmpl into a single template set. tmpl
+// is parsed last so that the blocks it defines override those of the base.
+func (env *Environment) parseTemplates(tmpl string, opts Options, f output.Format, custom map[string]interface{}) (*templateSet, error) {
+  set := env.newTemplateSet(opts.Name, opts, f, custom)
+
+  if opts.Base != "" {
+    if err := set.parse(opts.BaseName, opts.Base, opts.LeftDelim, opts.RightDelim); err != nil {
+      return nil, err
+    }
+  }
+
+  if err := set.parse(opts.Name, tmpl, opts.LeftDelim, opts.RightDelim); err != nil {
+    return nil, err
+  }
+
+  for _, tree := range set.trees() {
+    instrument(tree)
+  }
+
+  return set, nil
+}
+
+// parsePartials parses each of the partial sources into a set of its own.
+// A partial that fails to parse keeps its error, which is reported when it
+// is included.
+func (env *Environment) parsePartials(opts Options, f output.Format, sources map[string]string, custom map[string]interface{}) map[string]*partialTemplate {
+  parsed := make(map[string]*partialTemplate, len(sources))
+  for name, source := range sources {
+    pt := &partialTemplate{set: env.newTemplateSet(name, opts, f, custom)}
+    if pt.err = pt.set.parse(name, source, "", ""); pt.err == nil {
+      for _, tree := range pt.set.trees() {
+        instrument(tree)
+      }
+    }
+    parsed[name] = pt
+  }
+  return parsed
+}
+
+// newTemplateSet returns an empty set for the named template with the
+// functions of env and the options of opts.
+func (env *Environment) newTemplateSet(name string, opts Options, f output.Format, custom map[string]interface{}) *templateSet {
+  set := newTemplateSet(name, f.IsPlainText)
+  set.funcs(env.funcs)
+  set.funcs(custom)
+  if opts.MissingKey != "" {
+    set.option("missingkey=" + opts.MissingKey)
+  }
+  return set
+}
+
+// outputFormat returns the output format with the given name, HTML if it is
+// empty.
+func outputFormat(name string) (output.Format, error) {
+  if name == "" {
+    return output.HTMLFormat, nil
+  }
+  f, found := output.DefaultFormats.GetByName(name)
+  if !found {
+    return f, fmt.Errorf("unknown output format %q", name)
+  }
+  return f, nil
+}
+
+// isEmptyTree reports whether tree has no content outside of its
+// {{ define }} blocks.
+func isEmptyTree(tree *parse.Tree) bool {
+  return tree == nil || parse.IsEmptyTree(tree.Root)
+}
+
+// Render parses tmpl and executes it against data in the default
+// environment. The result holds the output produced so far and every error
+// raised; the first of them is also returned.
+func Render(data interface{}, tmpl string, opts Options) (Result, error) {
+  return defaultEnvironment.Render(data, tmpl, opts)
+}
+
+// Render parses tmpl and executes it against data.
+func (env *Environment) Render(data interface{}, tmpl string, opts Options) (Result, error) {
+  return env.Prepare(tmpl, opts).Render(data)
+}
+
+// Render executes the prepared template against data.
+func (p *Prepared) Render(data interface{}) (Result, error) {
+  return p.render(data).values()
+}
+
+// RenderTo executes the prepared template against data, writing the output
+// to w as it is produced rather than to the HTML of the result. Execution
+// stops with ctx's error once ctx is done.
+func (p *Prepared) RenderTo(ctx context.Context, w io.Writer, data interface{}) (Result, error) {
+  return p.renderTo(ctx, w, data).values()
+}
+
+func (p *Prepared) render(data interface{}) *Result {
+  var buf bytes.Buffer
+  result := p.renderTo(context.Background(), &buf, data)
+  result.HTML = buf.String()
+  return result
+}
+
+func (p *Prepared) renderTo(ctx context.Context, w io.Writer, data interface{}) *Result {
+  result := &Result{}
+  if p.err != nil {
+    result.addError(p.err)
+    return result
+  }
+
+  // The prepared set is never executed itself so that each render can clone
+  // it, bind the partial functions to a fresh partialCached cache and pick
+  // up the latest implementation of each registered function.
+  set, err := p.set.clone()
+  if err != nil {
+    result.addError(err)
+    return result
+  }
+  custom, _ := p.env.custom.snapshot()
+  funcs := make(map[string]interface{})
+  bind := func(s *templateSet) {
+    s.funcs(custom)
+    s.funcs(funcs)
+  }
+
+  scratch := p.scratch()
+  data = withScratch(data, scratch)
+  b := newBudget(ctx, p.limits)
+  w = b.writer(w)
+  lookup := p.partialLookup(bind)
+  var m *sourceMapper
+  if p.sources != nil {
+    m = newSourceMapper(p.sources)
+    w = m.writer(w)
+    lookup = m.lookup(lookup)
+    funcs[sourceFunc] = m.mark
+  }
+
+  ns := partials.New(lookup)
+  for name, fn := range map[string]interface{}{
+    stepFunc: b.step,
+    callFunc: b.call,
+    "partial": func(name string, contextList ...interface{}) (interface{}, error) {
+      return b.include(name, func() (interface{}, error) {
+        return ns.Include(name, contextList...)
+      })
+    },
+    "partialCached": func(name string, context interface{}, variants ...interface{}) (interface{}, error) {
+      return b.include(name, func() (interface{}, error) {
+        return ns.IncludeCached(name, context, variants...)
+      })
+    },
+  } {
+    funcs[name] = fn
+  }
+  bind(set)
+
+  if err := execute(set, p.entry, w, data); err != nil {
+    result.addError(err)
+  }
+  result.MissingKeys, result.MissingKeysTruncated = findMissingKeys(p.set, p.partials, p.entry, data)
+  if m != nil {
+    result.SourceMap = m.spans()
+  }
+  result.Scratch = scratch.Values()
+  return result
+}
+
+// scratch returns the scratch a render writes to: the environment's if the
+// options asked for it to persist, or a fresh one so that values set by one
+// render, such as counters, do not leak into the next.
+func (p *Prepared) scratch() *hugolib.Scratch {
+  if p.persist {
+    return p.env.scratch
+  }
+  return hugolib.NewScratch()
+}
+
+// withScratch returns data with scratch added under scratchKey. Maps are
+// copied rather than modified, and a Scratch field of the entry takes
+// precedence. Nil data becomes a map holding only the scratch; other data
+// is returned as is.
+func withScratch(data interface{}, scratch *hugolib.Scratch) interface{} {
+  switch d := data.(type) {
+  case nil:
+    return map[string]interface{}{scratchKey: scratch}
+  case map[string]interface{}:
+    if _, found := d[scratchKey]; found {
+      return data
+    }
+    m := make(map[string]interface{}, len(d)+1)
+    for key, value := range d {
+      m[key] = value
+    }
+    m[scratchKey] = scratch
+    return m
+  }
+  return data
+}
+
+// partialLookup returns a lookup of the partials of p for a single render.
+// Each partial is cloned the first time it is included, and given the
+// functions of the render by bind.
+func (p *Prepared) partialLookup(bind func(*templateSet)) partials.Lookup {
+  sets := make(map[string]*templateSet)
+  return func(name string) partials.Executer {
+    pt, found := p.partials[name]
+    if !found {
+      return nil
+    }
+    if pt.err != nil {
+      return errorExecuter{pt.err}
+    }
+    set, found := sets[name]
+    if !found {
+      var err error
+      if set, err = pt.set.clone(); err != nil {
+        return errorExecuter{err}
+      }
+      bind(set)
+      sets[name] = set
+    }
+    return set.lookup(name)
+  }
+}
+
+// errorExecuter stands in for a partial that cannot be executed, failing
+// with its error.
+type errorExecuter struct {
+  err error
+}
+
+func (e errorExecuter) Execute(w io.Writer, data interface{}) error {
+  return e.err
+}
+
+// execute runs the named template of set, converting panics raised by
+// template functions into errors.
+func execute(set *templateSet, name string, w io.Writer, data interface{}) (err error) {
+  defer func() {
+    if r := recover(); r != nil {
+      err = recoverError(r)
+    }
+  }()
+  return set.execute(w, name, data)
+}
@@ -0,0 +1,140 @@
+package render
+
+import (
+  "strings"
+  "testing"
+)
+
+func TestPartials(t *testing.T) {
+  env := NewEnvironment(nil)
+  env.RegisterPartial("greeting.html", `Hello {{ .name }}{{ template "punct" }}{{ define "punct" }}!{{ end }}`)
+  env.RegisterPartial("broken.html", `{{ .name `)
+  env.RegisterPartial("main.html", `{{ define "main" }}partial main{{ end }}`)
+
+  tests := []struct {
+    name string
+    tmpl string
+    base string
+    html string
+    err  string
+  }{
+    {"include", `{{ partial "greeting.html" . }}`, "", "Hello Jo!", ""},
+    {"broken partial unused", `{{ .name }}`, "", "Jo", ""},
+    {"broken partial used", `a{{ partial "broken.html" . }}`, "", "a", "partials/broken.html:1: unclosed action"},
+    {"define does not leak", `{{ partial "greeting.html" . }}{{ define "punct" }}?{{ end }}{{ template "punct" }}`, "", "Hello Jo!?", ""},
+    {"define does not override base", `{{ define "title" }}T{{ end }}`, `{{ block "title" . }}{{ end }}|{{ block "main" . }}base main{{ end }}`, "T|base main", ""},
+    {"missing partial", `{{ partial "nope.html" . }}`, "", "", `partial "nope.html" not found`},
+  }
+
+  for _, test := range tests {
+    result, err := env.Render(map[string]interface{}{"name": "Jo"}, test.tmpl, Options{Base: test.base})
+    if result.HTML != test.html {
+      t.Errorf("%s: got %q, want %q", test.name, result.HTML, test.html)
+    }
+    switch {
+    case test.err == "" && err != nil:
+      t.Errorf("%s: unexpected error %v", test.name, err)
+    case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+      t.Errorf("%s: got error %v, want one containing %q", test.name, err, test.err)
+    }
+  }
+}
+
+func TestBase(t *testing.T) {
+  env := NewEnvironment(nil)
+  base := `[{{ block "title" . }}default{{ end }}|{{ block "main" . }}{{ .name }}{{ end }}]`
+
+  tests := []struct {
+    name string
+    tmpl string
+    base string
+    html string
+    err  string
+  }{
+    {"no base", `{{ .name }}`, "", "Jo", ""},
+    {"base blocks", `{{ define "title" }}T{{ end }}`, base, "[T|Jo]", ""},
+    {"all blocks", `{{ define "title" }}T{{ end }}{{ define "main" }}M{{ end }}`, base, "[T|M]", ""},
+    {"content outside blocks", `x{{ define "title" }}T{{ end }}`, base, "x", ""},
+    {"base parse error", `{{ define "title" }}T{{ end }}`, `{{ .name `, "", "baseof.html:1: unclosed action"},
+  }
+
+  for _, test := range tests {
+    result, err := env.Render(map[string]interface{}{"name": "Jo"}, test.tmpl, Options{Base: test.base})
+    if result.HTML != test.html {
+      t.Errorf("%s: got %q, want %q", test.name, result.HTML, test.html)
+    }
+    switch {
+    case test.err == "" && err != nil:
+      t.Errorf("%s: unexpected error %v", test.name, err)
+    case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+      t.Errorf("%s: got error %v, want one containing %q", test.name, err, test.err)
+    }
+  }
+}
+
+func TestPartialsPlainText(t *testing.T) {
+  env := NewEnvironment(nil)
+  env.RegisterPartial("item.json", `{"name": {{ printf "%q" .name }}}`)
+
+  result, err := env.Render(map[string]interface{}{"name": "<b>"}, `[{{ partial "item.json" . }}]`, Options{Format: "JSON"})
+  if err != nil {
+    t.Fatal(err)
+  }
+  if want := `[{"name": "<b>"}]`; result.HTML != want {
+    t.Errorf("got %q, want %q", result.HTML, want)
+  }
+}
+
+func TestFormats(t *testing.T) {
+  env := NewEnvironment(nil)
+
+  tests := []struct {
+    format string
+    html   string
+    err    string
+  }{
+    {"", "&lt;b&gt;", ""},
+    {"HTML", "&lt;b&gt;", ""},
+    {"json", "<b>", ""},
+    {"RSS", "<b>", ""},
+    {"nope", "", `unknown output format "nope"`},
+  }
+
+  for _, test := range tests {
+    result, err := env.Render(map[string]interface{}{"name": "<b>"}, `{{ .name }}`, Options{Format: test.format})
+    if result.HTML != test.html {
+      t.Errorf("%q: got %q, want %q", test.format, result.HTML, test.html)
+    }
+    switch {
+    case test.err == "" && err != nil:
+      t.Errorf("%q: unexpected error %v", test.format, err)
+    case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+      t.Errorf("%q: got error %v, want one containing %q", test.format, err, test.err)
+    }
+  }
+}
+
+func TestPartialsScratch(t *testing.T) {
+  env := NewEnvironment(nil)
+  env.RegisterPartial("count.html", `{{ .Scratch.Add "n" 1 }}{{ .Scratch.Get "n" }}`)
+
+  tests := []struct {
+    name string
+    tmpl string
+    html string
+  }{
+    {"shared", `{{ .Scratch.Set "n" 1 }}{{ partial "count.html" . }}{{ .Scratch.Get "n" }}`, "22"},
+    {"scoped", `{{ .Scratch.Set "n" 1 }}{{ partial "count.html" (dict "Scratch" .Scratch.Scope) }}{{ .Scratch.Get "n" }}`, "21"},
+  }
+
+  for _, test := range tests {
+    result, err := env.Render(nil, test.tmpl, Options{})
+    if err != nil {
+      t.Errorf("%s: %v", test.name, err)
+      continue
+    }
+    if result.HTML != test.html {
+      t.Errorf("%s: got %q, want %q", test.name, result.HTML, test.html)
+    }
+  }
+}
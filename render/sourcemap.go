@@ -0,0 +1,609 @@
+package render
+
+import (
+  "fmt"
+  "io"
+  "reflect"
+  "sort"
+  "strconv"
+  "strings"
+  texttemplate "text/template"
+  "text/template/parse"
+
+  "github.com/erquhart/netlify-cms-template-parser-go/hugo/tpl/partials"
+)
+
+// sourceFunc is the name of the function instrumentSources inserts into
+// templates to follow where their output comes from.
+const sourceFunc = "_src"
+
+// SourceSpan associates a range of the rendered output with the action that
+// printed it.
+type SourceSpan struct {
+  // Start and End are the byte offsets of the output, End excluded.
+  Start int
+  End   int
+
+  Name   string
+  Line   int
+  Column int
+
+  // Action is the action as written in the template, e.g. "{{.name}}".
+  Action string
+
+  // Path is the path in the data of the value printed, such as "body" or
+  // "authors.2.name". It is empty unless the action prints a single field
+  // that can be traced back to the data, possibly through functions as in
+  // {{ .body | markdownify }}.
+  Path string
+}
+
+// sourceExpr is a field chain relative to dot or to a variable, as in
+// ".author.name" or "$post.title".
+type sourceExpr struct {
+  variable string
+  fields   []string
+}
+
+// sourceNode is a node instrumented by instrumentSources.
+type sourceNode struct {
+  name   string
+  line   int
+  column int
+  action string
+  // expr is the field chain the node prints or binds, if any.
+  expr *sourceExpr
+  // decl are the variables declared by the node's pipeline.
+  decl []string
+}
+
+// sourceTable holds the instrumented nodes of template sets, indexed by the
+// id passed to sourceFunc.
+type sourceTable struct {
+  nodes []*sourceNode
+}
+
+// instrumentSources inserts calls to sourceFunc into every template of sets,
+// so that a sourceMapper can follow which action printed each part of the
+// output and which data it printed:
+//
+//   - {{ if _src "start" id }}{{ end }} and {{ if _src "end" id }}{{ end }}
+//     surround each action that prints something;
+//   - _src "range", "with", "call" and "var" are appended to the pipelines of
+//     ranges, withs, template calls and variable declarations, passing their
+//     value through unchanged;
+//   - {{ if _src "iter" id }}{{ end }} starts each range iteration,
+//     {{ if _src "else" id }}{{ end }} each else branch of a range or with,
+//     and {{ if _src "pop" id }}{{ end }} follows the range, with or
+//     template call;
+//   - the context passed to a partial is wrapped in (_src "ctx" id ...).
+func instrumentSources(sets ...*templateSet) *sourceTable {
+  t := &sourceTable{}
+  for _, set := range sets {
+    for _, tree := range set.trees() {
+      if tree != nil {
+        t.list(tree, tree.Root)
+      }
+    }
+  }
+  return t
+}
+
+// add records node and returns its id. action is only set for the nodes
+// printing something.
+func (t *sourceTable) add(tree *parse.Tree, node parse.Node, action string, expr *sourceExpr, decl []*parse.VariableNode) int {
+  n := &sourceNode{name: tree.ParseName, action: action, expr: expr}
+  n.line, n.column = nodeLocation(tree, node)
+  for _, v := range decl {
+    n.decl = append(n.decl, v.Ident[0])
+  }
+  t.nodes = append(t.nodes, n)
+  return len(t.nodes) - 1
+}
+
+func (t *sourceTable) list(tree *parse.Tree, list *parse.ListNode) {
+  if list == nil {
+    return
+  }
+
+  nodes := make([]parse.Node, 0, len(list.Nodes))
+  for _, node := range list.Nodes {
+    switch n := node.(type) {
+    case *parse.ActionNode:
+      // The action is printed before partial contexts are wrapped.
+      action := n.String()
+      t.pipe(tree, n.Pipe)
+      if len(n.Pipe.Decl) > 0 {
+        id := t.add(tree, n, "", pipeExpr(n.Pipe), n.Pipe.Decl)
+        appendSourceCommand(n.Pipe, "var", id)
+        break
+      }
+      id := t.add(tree, n, action, printedExpr(n.Pipe), nil)
+      nodes = append(nodes, sourceMarker(n.Position(), "start", id), n, sourceMarker(n.Position(), "end", id))
+      continue
+    case *parse.IfNode:
+      t.pipe(tree, n.Pipe)
+      if len(n.Pipe.Decl) > 0 {
+        id := t.add(tree, n, "", pipeExpr(n.Pipe), n.Pipe.Decl)
+        appendSourceCommand(n.Pipe, "var", id)
+      }
+      t.list(tree, n.List)
+      t.list(tree, n.ElseList)
+    case *parse.WithNode:
+      t.pipe(tree, n.Pipe)
+      id := t.add(tree, n, "", pipeExpr(n.Pipe), n.Pipe.Decl)
+      appendSourceCommand(n.Pipe, "with", id)
+      t.list(tree, n.List)
+      t.elseList(tree, n.ElseList, id)
+      nodes = append(nodes, n, sourceMarker(n.Position(), "pop", id))
+      continue
+    case *parse.RangeNode:
+      t.pipe(tree, n.Pipe)
+      id := t.add(tree, n, "", pipeExpr(n.Pipe), n.Pipe.Decl)
+      appendSourceCommand(n.Pipe, "range", id)
+      t.list(tree, n.List)
+      if n.List != nil {
+        n.List.Nodes = append([]parse.Node{sourceMarker(n.Position(), "iter", id)}, n.List.Nodes...)
+      }
+      t.elseList(tree, n.ElseList, id)
+      nodes = append(nodes, n, sourceMarker(n.Position(), "pop", id))
+      continue
+    case *parse.TemplateNode:
+      if n.Pipe != nil {
+        t.pipe(tree, n.Pipe)
+        id := t.add(tree, n, "", pipeExpr(n.Pipe), nil)
+        appendSourceCommand(n.Pipe, "call", id)
+        nodes = append(nodes, n, sourceMarker(n.Position(), "pop", id))
+        continue
+      }
+    }
+    nodes = append(nodes, node)
+  }
+  list.Nodes = nodes
+}
+
+func (t *sourceTable) elseList(tree *parse.Tree, list *parse.ListNode, id int) {
+  if list == nil {
+    return
+  }
+  t.list(tree, list)
+  list.Nodes = append([]parse.Node{sourceMarker(list.Position(), "else", id)}, list.Nodes...)
+}
+
+// pipe wraps the context passed to each call to partial or partialCached in
+// pipe, including those nested in parentheses.
+func (t *sourceTable) pipe(tree *parse.Tree, pipe *parse.PipeNode) {
+  if pipe == nil {
+    return
+  }
+  for _, cmd := range pipe.Cmds {
+    for _, arg := range cmd.Args {
+      if p, ok := arg.(*parse.PipeNode); ok {
+        t.pipe(tree, p)
+      }
+    }
+
+    ident, ok := cmd.Args[0].(*parse.IdentifierNode)
+    if !ok || (ident.Ident != "partial" && ident.Ident != "partialCached") || len(cmd.Args) < 3 {
+      continue
+    }
+    context := cmd.Args[2]
+    id := t.add(tree, context, "", argExpr(context), nil)
+    pos := context.Position()
+    cmd.Args[2] = &parse.PipeNode{
+      NodeType: parse.NodePipe,
+      Pos:      pos,
+      Cmds:     []*parse.CommandNode{commandNode(pos, sourceArgs(pos, "ctx", id, context)...)},
+    }
+  }
+}
+
+// sourceArgs returns the arguments of a call to sourceFunc.
+func sourceArgs(pos parse.Pos, op string, id int, args ...parse.Node) []parse.Node {
+  return append([]parse.Node{
+    parse.NewIdentifier(sourceFunc).SetPos(pos),
+    &parse.StringNode{NodeType: parse.NodeString, Pos: pos, Quoted: strconv.Quote(op), Text: op},
+    &parse.NumberNode{
+      NodeType: parse.NodeNumber,
+      Pos:      pos,
+      IsInt:    true,
+      IsUint:   true,
+      IsFloat:  true,
+      Int64:    int64(id),
+      Uint64:   uint64(id),
+      Float64:  float64(id),
+      Text:     strconv.Itoa(id),
+    },
+  }, args...)
+}
+
+// sourceMarker returns the node for {{ if _src op id }}{{ end }}.
+func sourceMarker(pos parse.Pos, op string, id int) parse.Node {
+  return markerNode(pos, sourceArgs(pos, op, id)...)
+}
+
+// appendSourceCommand appends _src op id to pipe, which passes the value of
+// the pipeline through.
+func appendSourceCommand(pipe *parse.PipeNode, op string, id int) {
+  pipe.Cmds = append(pipe.Cmds, commandNode(pipe.Position(), sourceArgs(pipe.Position(), op, id)...))
+}
+
+// isSourceCommand reports whether cmd was inserted by instrumentSources.
+func isSourceCommand(cmd *parse.CommandNode) bool {
+  ident, ok := cmd.Args[0].(*parse.IdentifierNode)
+  return ok && ident.Ident == sourceFunc
+}
+
+// unmarked returns cmds without the commands inserted by instrumentSources,
+// keeping the argument a command wrapping a partial context passes through.
+func unmarked(cmds []*parse.CommandNode) []*parse.CommandNode {
+  out := make([]*parse.CommandNode, 0, len(cmds))
+  for _, cmd := range cmds {
+    switch {
+    case !isSourceCommand(cmd):
+      out = append(out, cmd)
+    case len(cmd.Args) > 3:
+      out = append(out, commandNode(cmd.Position(), cmd.Args[3:]...))
+    }
+  }
+  return out
+}
+
+// pipeExpr returns the field chain pipe evaluates to, if it is made up of
+// nothing else.
+func pipeExpr(pipe *parse.PipeNode) *sourceExpr {
+  if len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+    return nil
+  }
+  return argExpr(pipe.Cmds[0].Args[0])
+}
+
+// printedExpr returns the only field chain among the arguments of pipe, so
+// that {{ .body | markdownify }} is traced back to body.
+func printedExpr(pipe *parse.PipeNode) *sourceExpr {
+  var expr *sourceExpr
+  for _, cmd := range pipe.Cmds {
+    for _, arg := range cmd.Args {
+      if e := argExpr(arg); e != nil {
+        if expr != nil {
+          return nil
+        }
+        expr = e
+      }
+    }
+  }
+  return expr
+}
+
+func argExpr(node parse.Node) *sourceExpr {
+  switch n := node.(type) {
+  case *parse.DotNode:
+    return &sourceExpr{}
+  case *parse.FieldNode:
+    return &sourceExpr{fields: n.Ident}
+  case *parse.VariableNode:
+    return &sourceExpr{variable: n.Ident[0], fields: n.Ident[1:]}
+  case *parse.ChainNode:
+    if base := argExpr(n.Node); base != nil {
+      fields := append(append([]string{}, base.fields...), n.Field...)
+      return &sourceExpr{variable: base.variable, fields: fields}
+    }
+  case *parse.PipeNode:
+    return pipeExpr(n)
+  }
+  return nil
+}
+
+// dataPath is a path into the data passed to a template. A nil path is
+// unknown; an empty one is the data itself.
+type dataPath []string
+
+func (p dataPath) child(names ...string) dataPath {
+  if p == nil {
+    return nil
+  }
+  c := make(dataPath, 0, len(p)+len(names))
+  return append(append(c, p...), names...)
+}
+
+func (p dataPath) String() string {
+  return strings.Join(p, ".")
+}
+
+// sourceFrame is what a sourceMapper knows about dot and the variables in
+// scope within a template, range or with.
+type sourceFrame struct {
+  // scope is set for the frame of a template or partial, whose dot is $.
+  scope bool
+  dot   dataPath
+  vars  map[string]dataPath
+
+  // The collection being ranged over, its map keys if it is a map, and the
+  // current iteration.
+  coll  dataPath
+  keys  []string
+  index int
+}
+
+// sourceOutput is the output of the template or of a partial being
+// executed.
+type sourceOutput struct {
+  written int
+  spans   []*SourceSpan
+  // open are the offsets at which the actions being printed started.
+  open []int
+  // partial is the output of the last partial executed while printing the
+  // current action.
+  partial *sourceOutput
+}
+
+// sourceMapper follows the execution of a template instrumented by
+// instrumentSources, recording the spans of output printed by each action.
+type sourceMapper struct {
+  table   *sourceTable
+  frames  []*sourceFrame
+  outputs []*sourceOutput
+  // context is the path of the context passed to the next partial.
+  context dataPath
+}
+
+func newSourceMapper(table *sourceTable) *sourceMapper {
+  return &sourceMapper{
+    table:   table,
+    frames:  []*sourceFrame{{scope: true, dot: dataPath{}}},
+    outputs: []*sourceOutput{{}},
+  }
+}
+
+// spans returns the spans of the output of the template.
+func (m *sourceMapper) spans() []*SourceSpan {
+  spans := m.outputs[0].spans
+  if spans == nil {
+    spans = []*SourceSpan{}
+  }
+  return spans
+}
+
+// writer returns a writer counting the bytes of output written to w.
+func (m *sourceMapper) writer(w io.Writer) io.Writer {
+  return &sourceWriter{w: w, out: m.outputs[0]}
+}
+
+type sourceWriter struct {
+  w   io.Writer
+  out *sourceOutput
+}
+
+func (w *sourceWriter) Write(p []byte) (int, error) {
+  n, err := w.w.Write(p)
+  w.out.written += n
+  return n, err
+}
+
+// lookup wraps the partials returned by lookup so that their output is
+// mapped too.
+func (m *sourceMapper) lookup(lookup partials.Lookup) partials.Lookup {
+  return func(name string) partials.Executer {
+    e := lookup(name)
+    if e == nil {
+      return nil
+    }
+    return &sourceExecuter{m: m, e: e}
+  }
+}
+
+type sourceExecuter struct {
+  m *sourceMapper
+  e partials.Executer
+}
+
+// Execute executes the partial with dot set to the context last wrapped by
+// _src "ctx", counting its output separately. The spans of the partial are
+// kept if the action that called it prints exactly its output.
+func (e *sourceExecuter) Execute(w io.Writer, data interface{}) error {
+  m := e.m
+  frames, outputs := len(m.frames), len(m.outputs)
+  out := &sourceOutput{}
+  m.frames = append(m.frames, &sourceFrame{scope: true, dot: m.context})
+  m.outputs = append(m.outputs, out)
+  m.context = nil
+
+  err := e.e.Execute(&sourceWriter{w: w, out: out}, data)
+
+  m.frames = m.frames[:frames]
+  m.outputs = m.outputs[:outputs]
+  m.output().partial = out
+  return err
+}
+
+// IsPlainText reports whether the partial is a text/template, as Include
+// returns the output of those as a string rather than as HTML.
+func (e *sourceExecuter) IsPlainText() bool {
+  _, ok := e.e.(*texttemplate.Template)
+  return ok
+}
+
+func (m *sourceMapper) output() *sourceOutput {
+  return m.outputs[len(m.outputs)-1]
+}
+
+func (m *sourceMapper) frame() *sourceFrame {
+  return m.frames[len(m.frames)-1]
+}
+
+// mark is bound to sourceFunc. It returns the last of args, if any, so that
+// it can be appended to a pipeline, and false otherwise, so that the
+// {{ if }} markers render nothing.
+func (m *sourceMapper) mark(op string, id int, args ...interface{}) (interface{}, error) {
+  if id < 0 || id >= len(m.table.nodes) {
+    return nil, fmt.Errorf("%s: unknown node %d", sourceFunc, id)
+  }
+  node := m.table.nodes[id]
+  var result interface{} = false
+  if len(args) > 0 {
+    result = args[len(args)-1]
+  }
+
+  switch op {
+  case "start":
+    out := m.output()
+    out.open = append(out.open, out.written)
+    out.partial = nil
+  case "end":
+    m.end(node)
+  case "var":
+    m.declare(node.decl, m.resolve(node.expr))
+  case "with":
+    f := &sourceFrame{dot: m.resolve(node.expr)}
+    m.frames = append(m.frames, f)
+    m.declare(node.decl, f.dot)
+  case "range":
+    m.frames = append(m.frames, &sourceFrame{
+      dot:   m.frame().dot,
+      coll:  m.resolve(node.expr),
+      keys:  mapKeys(result),
+      index: -1,
+    })
+  case "iter":
+    f := m.frame()
+    f.index++
+    key := strconv.Itoa(f.index)
+    if f.index < len(f.keys) {
+      key = f.keys[f.index]
+    }
+    f.dot = f.coll.child(key)
+    switch len(node.decl) {
+    case 1:
+      m.declare(node.decl, f.dot)
+    case 2:
+      m.declare(node.decl[:1], nil)
+      m.declare(node.decl[1:], f.dot)
+    }
+  case "else":
+    if len(m.frames) > 1 {
+      m.frame().dot = m.frames[len(m.frames)-2].dot
+    }
+  case "pop":
+    if len(m.frames) > 1 {
+      m.frames = m.frames[:len(m.frames)-1]
+    }
+  case "call":
+    m.frames = append(m.frames, &sourceFrame{scope: true, dot: m.resolve(node.expr)})
+  case "ctx":
+    m.context = m.resolve(node.expr)
+  default:
+    return nil, fmt.Errorf("%s: unknown operation %q", sourceFunc, op)
+  }
+  return result, nil
+}
+
+// end records the span printed by the action node, along with those of the
+// partial it printed, if any.
+func (m *sourceMapper) end(node *sourceNode) {
+  out := m.output()
+  if len(out.open) == 0 {
+    return
+  }
+  start := out.open[len(out.open)-1]
+  out.open = out.open[:len(out.open)-1]
+  if out.written == start {
+    return
+  }
+
+  out.spans = append(out.spans, &SourceSpan{
+    Start:  start,
+    End:    out.written,
+    Name:   node.name,
+    Line:   node.line,
+    Column: node.column,
+    Action: node.action,
+    Path:   m.resolve(node.expr).String(),
+  })
+
+  if p := out.partial; p != nil && p.written == out.written-start {
+    for _, span := range p.spans {
+      s := *span
+      s.Start += start
+      s.End += start
+      out.spans = append(out.spans, &s)
+    }
+  }
+  out.partial = nil
+}
+
+// declare binds the variables names to path in the current frame.
+func (m *sourceMapper) declare(names []string, path dataPath) {
+  f := m.frame()
+  if f.vars == nil {
+    f.vars = make(map[string]dataPath)
+  }
+  for _, name := range names {
+    f.vars[name] = path
+  }
+}
+
+// resolve returns the path of the data expr refers to.
+func (m *sourceMapper) resolve(expr *sourceExpr) dataPath {
+  if expr == nil {
+    return nil
+  }
+  if expr.variable == "" {
+    return m.frame().dot.child(expr.fields...)
+  }
+
+  for i := len(m.frames) - 1; i >= 0; i-- {
+    f := m.frames[i]
+    if path, found := f.vars[expr.variable]; found {
+      return path.child(expr.fields...)
+    }
+    if f.scope {
+      if expr.variable == "$" {
+        return f.dot.child(expr.fields...)
+      }
+      break
+    }
+  }
+  return nil
+}
+
+// mapKeys returns the keys of v in the order range visits them if v is a
+// map, or nil otherwise.
+func mapKeys(v interface{}) []string {
+  rv := reflect.ValueOf(v)
+  if rv.Kind() != reflect.Map {
+    return nil
+  }
+
+  keys := rv.MapKeys()
+  sort.Slice(keys, func(i, j int) bool {
+    a, b := keys[i], keys[j]
+    switch a.Kind() {
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+      return a.Int() < b.Int()
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+      return a.Uint() < b.Uint()
+    case reflect.Float32, reflect.Float64:
+      return a.Float() < b.Float()
+    case reflect.String:
+      return a.String() < b.String()
+    }
+    return fmt.Sprint(a.Interface()) < fmt.Sprint(b.Interface())
+  })
+
+  names := make([]string, len(keys))
+  for i, k := range keys {
+    names[i] = fmt.Sprint(k.Interface())
+  }
+  return names
+}
+
+// nodeLocation returns the line and column of node in tree's source.
+func nodeLocation(tree *parse.Tree, node parse.Node) (line, column int) {
+  location, _ := tree.ErrorContext(node)
+  parts := strings.Split(location, ":")
+  if len(parts) >= 3 {
+    line, _ = strconv.Atoi(parts[len(parts)-2])
+    column, _ = strconv.Atoi(parts[len(parts)-1])
+  }
+  return line, column
+}
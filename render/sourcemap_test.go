@@ -0,0 +1,147 @@
+package render
+
+import "testing"
+
+// span is the part of a SourceSpan checked by TestSourceMap, along with the
+// output it covers.
+type span struct {
+  text   string
+  name   string
+  column int
+  action string
+  path   string
+}
+
+func TestSourceMap(t *testing.T) {
+  env := NewEnvironment(nil)
+  env.RegisterPartial("author.html", `<b>{{ .name }}</b>`)
+
+  data := map[string]interface{}{
+    "title":  "A<B",
+    "url":    "/x y",
+    "js":     `a"b`,
+    "author": map[string]interface{}{"name": "Jo"},
+    "posts": []interface{}{
+      map[string]interface{}{"title": "one"},
+      map[string]interface{}{"title": "two"},
+    },
+  }
+
+  tests := []struct {
+    name  string
+    tmpl  string
+    html  string
+    spans []span
+  }{
+    {
+      name: "escaped text",
+      tmpl: `<h1>{{ .title }}</h1>`,
+      html: `<h1>A&lt;B</h1>`,
+      spans: []span{
+        {"A&lt;B", "preview", 7, "{{.title}}", "title"},
+      },
+    },
+    {
+      name: "attributes",
+      tmpl: `<a href="{{ .url }}" title="{{ .title }}">x</a>`,
+      html: `<a href="/x%20y" title="A&lt;B">x</a>`,
+      spans: []span{
+        {"/x%20y", "preview", 12, "{{.url}}", "url"},
+        {"A&lt;B", "preview", 31, "{{.title}}", "title"},
+      },
+    },
+    {
+      name: "script",
+      tmpl: `<script>var s = {{ .js }};</script>`,
+      html: `<script>var s = "a\"b";</script>`,
+      spans: []span{
+        {`"a\"b"`, "preview", 19, "{{.js}}", "js"},
+      },
+    },
+    {
+      name: "function",
+      tmpl: `{{ .title | printf "%s!" }}`,
+      html: `A&lt;B!`,
+      spans: []span{
+        {"A&lt;B!", "preview", 3, `{{.title | printf "%s!"}}`, "title"},
+      },
+    },
+    {
+      name: "range",
+      tmpl: `{{ range .posts }}<li>{{ .title }}</li>{{ end }}`,
+      html: `<li>one</li><li>two</li>`,
+      spans: []span{
+        {"one", "preview", 25, "{{.title}}", "posts.0.title"},
+        {"two", "preview", 25, "{{.title}}", "posts.1.title"},
+      },
+    },
+    {
+      name: "range variables",
+      tmpl: `{{ range $i, $p := .posts }}{{ $i }}:{{ $p.title }} {{ end }}`,
+      html: `0:one 1:two `,
+      spans: []span{
+        {"0", "preview", 31, "{{$i}}", ""},
+        {"one", "preview", 40, "{{$p.title}}", "posts.0.title"},
+        {"1", "preview", 31, "{{$i}}", ""},
+        {"two", "preview", 40, "{{$p.title}}", "posts.1.title"},
+      },
+    },
+    {
+      name: "with",
+      tmpl: `{{ with .author }}{{ .name }}{{ end }}`,
+      html: `Jo`,
+      spans: []span{
+        {"Jo", "preview", 21, "{{.name}}", "author.name"},
+      },
+    },
+    {
+      name: "template call",
+      tmpl: `{{ define "name" }}<b>{{ .name }}</b>{{ end }}{{ template "name" .author }}`,
+      html: `<b>Jo</b>`,
+      spans: []span{
+        {"Jo", "preview", 25, "{{.name}}", "author.name"},
+      },
+    },
+    {
+      name: "partial",
+      tmpl: `<p>{{ partial "author.html" .author }}</p>`,
+      html: `<p><b>Jo</b></p>`,
+      spans: []span{
+        {"<b>Jo</b>", "preview", 6, `{{partial "author.html" .author}}`, ""},
+        {"Jo", "partials/author.html", 6, "{{.name}}", "author.name"},
+      },
+    },
+  }
+
+  for _, test := range tests {
+    result, err := env.Render(data, test.tmpl, Options{SourceMap: true})
+    if err != nil {
+      t.Errorf("%s: %v", test.name, err)
+      continue
+    }
+    if result.HTML != test.html {
+      t.Errorf("%s: got %q, want %q", test.name, result.HTML, test.html)
+      continue
+    }
+    if len(result.SourceMap) != len(test.spans) {
+      t.Errorf("%s: got %d spans, want %d", test.name, len(result.SourceMap), len(test.spans))
+      continue
+    }
+    for i, s := range result.SourceMap {
+      got := span{result.HTML[s.Start:s.End], s.Name, s.Column, s.Action, s.Path}
+      if got != test.spans[i] || s.Line != 1 {
+        t.Errorf("%s: span %d: got %+v at line %d, want %+v at line 1", test.name, i, got, s.Line, test.spans[i])
+      }
+    }
+  }
+}
+
+func TestSourceMapOff(t *testing.T) {
+  result, err := Render(map[string]interface{}{"title": "x"}, `{{ .title }}`, Options{})
+  if err != nil {
+    t.Fatal(err)
+  }
+  if result.SourceMap != nil {
+    t.Errorf("got %d spans without SourceMap", len(result.SourceMap))
+  }
+}
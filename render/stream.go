@@ -0,0 +1,54 @@
+package render
+
+import (
+  "context"
+  "io"
+)
+
+// defaultChunkSize is the size of the chunks a stream delivers unless told
+// otherwise.
+const defaultChunkSize = 16 << 10
+
+// Stream parses tmpl and executes it against data, delivering the output to
+// w as it is produced. Execution stops with ctx's error once ctx is done.
+func (env *Environment) Stream(ctx context.Context, data interface{}, tmpl string, opts Options, w io.Writer) (Result, error) {
+  return env.Prepare(tmpl, opts).RenderTo(ctx, w, data)
+}
+
+// ChunkWriter buffers output and passes it on to deliver in chunks of at
+// least size bytes. deliver may block to hold back execution until the
+// reader has caught up; an error from it stops execution.
+type ChunkWriter struct {
+  size    int
+  buf     []byte
+  deliver func(chunk []byte) error
+}
+
+// NewChunkWriter returns a ChunkWriter delivering chunks of at least size
+// bytes, or of defaultChunkSize if size is not positive.
+func NewChunkWriter(size int, deliver func(chunk []byte) error) *ChunkWriter {
+  if size <= 0 {
+    size = defaultChunkSize
+  }
+  return &ChunkWriter{size: size, deliver: deliver}
+}
+
+func (w *ChunkWriter) Write(p []byte) (int, error) {
+  w.buf = append(w.buf, p...)
+  if len(w.buf) >= w.size {
+    if err := w.Flush(); err != nil {
+      return len(p), err
+    }
+  }
+  return len(p), nil
+}
+
+// Flush delivers the buffered output, if any.
+func (w *ChunkWriter) Flush() error {
+  if len(w.buf) == 0 {
+    return nil
+  }
+  chunk := w.buf
+  w.buf = nil
+  return w.deliver(chunk)
+}
@@ -0,0 +1,126 @@
+package render
+
+import (
+  htmltemplate "html/template"
+  "io"
+  texttemplate "text/template"
+  "text/template/parse"
+
+  "github.com/erquhart/netlify-cms-template-parser-go/hugo/tpl/partials"
+)
+
+// templateSet is a set of associated templates backed by html/template, or
+// by text/template for plain text output formats such as JSON or RSS.
+type templateSet struct {
+  html *htmltemplate.Template
+  text *texttemplate.Template
+}
+
+// newTemplateSet returns an empty set whose first template is called name.
+func newTemplateSet(name string, plainText bool) *templateSet {
+  if plainText {
+    return &templateSet{text: texttemplate.New(name)}
+  }
+  return &templateSet{html: htmltemplate.New(name)}
+}
+
+// option sets options such as "missingkey=zero" on the set.
+func (s *templateSet) option(opt ...string) {
+  if s.text != nil {
+    s.text.Option(opt...)
+  } else {
+    s.html.Option(opt...)
+  }
+}
+
+// funcs adds the elements of m to the functions available to the set.
+func (s *templateSet) funcs(m map[string]interface{}) {
+  if s.text != nil {
+    s.text.Funcs(m)
+  } else {
+    s.html.Funcs(m)
+  }
+}
+
+// parse parses source as the body of the named template, adding it to the
+// set. Empty delimiters stand for the default "{{" and "}}". The first
+// template of the set is parsed into the set itself, as text/template's
+// Clone replaces the entry under its name with the root.
+func (s *templateSet) parse(name, source, left, right string) error {
+  var err error
+  if s.text != nil {
+    t := s.text
+    if t.Name() != name {
+      t = t.New(name)
+    }
+    _, err = t.Delims(left, right).Parse(source)
+  } else {
+    t := s.html
+    if t.Name() != name {
+      t = t.New(name)
+    }
+    _, err = t.Delims(left, right).Parse(source)
+  }
+  if err != nil {
+    return &parseError{err: err, source: source, left: left, right: right}
+  }
+  return nil
+}
+
+// lookup returns the named template, or nil if there is none.
+func (s *templateSet) lookup(name string) partials.Executer {
+  if s.text != nil {
+    if t := s.text.Lookup(name); t != nil {
+      return t
+    }
+  } else if t := s.html.Lookup(name); t != nil {
+    return t
+  }
+  return nil
+}
+
+// tree returns the parse tree of the named template, or nil if there is none.
+func (s *templateSet) tree(name string) *parse.Tree {
+  if s.text != nil {
+    if t := s.text.Lookup(name); t != nil {
+      return t.Tree
+    }
+  } else if t := s.html.Lookup(name); t != nil {
+    return t.Tree
+  }
+  return nil
+}
+
+// trees returns the parse trees of every template in the set.
+func (s *templateSet) trees() []*parse.Tree {
+  var trees []*parse.Tree
+  if s.text != nil {
+    for _, t := range s.text.Templates() {
+      trees = append(trees, t.Tree)
+    }
+  } else {
+    for _, t := range s.html.Templates() {
+      trees = append(trees, t.Tree)
+    }
+  }
+  return trees
+}
+
+// clone returns a copy of the set that can be given its own functions and
+// executed without affecting s.
+func (s *templateSet) clone() (*templateSet, error) {
+  if s.text != nil {
+    t, err := s.text.Clone()
+    return &templateSet{text: t}, err
+  }
+  t, err := s.html.Clone()
+  return &templateSet{html: t}, err
+}
+
+// execute applies the named template to data, writing the output to w.
+func (s *templateSet) execute(w io.Writer, name string, data interface{}) error {
+  if s.text != nil {
+    return s.text.ExecuteTemplate(w, name, data)
+  }
+  return s.html.ExecuteTemplate(w, name, data)
+}
@@ -0,0 +1,17 @@
+require('./wasm_exec.js');
+
+// load runs the WebAssembly build of the parser, given as the bytes of
+// main.wasm or read from next to this file if omitted, and resolves to the
+// goTemplateParser object it exports. It has the same API as the one
+// exported by main.js.
+async function load(bytes) {
+  if (bytes === undefined) {
+    bytes = require('fs').readFileSync(require('path').join(__dirname, 'main.wasm'));
+  }
+  const go = new Go();
+  const { instance } = await WebAssembly.instantiate(bytes, go.importObject);
+  go.run(instance);
+  return globalThis.goTemplateParser;
+}
+
+module.exports = { load };